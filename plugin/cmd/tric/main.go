@@ -0,0 +1,25 @@
+// Command tric creates and runs workflows with the apptainer binary, without
+// loading the TRIC plugin into Apptainer.
+package main
+
+import (
+	"os"
+
+	"workflow_creation/workflow"
+
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	rootCmd := &cobra.Command{
+		Use:          "tric",
+		Short:        "Create and run workflows of individual containers",
+		Long:         "Create, run, verify, inspect and export workflows of individual application, input and output containers, using the apptainer binary found in $PATH",
+		SilenceUsage: true,
+	}
+	rootCmd.AddCommand(workflow.Commands("tric")...)
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -1,9 +1,11 @@
 module workflow_creation
 
-go 1.13
+go 1.18
 
 require (
+	github.com/apptainer/sif/v2 v2.11.0
 	github.com/satori/go.uuid v1.2.1-0.20180404165556-75cca531ea76 // indirect
+	github.com/sigstore/sigstore v1.6.4
 	github.com/spf13/cobra v1.1.3
 	github.com/sylabs/sif v1.2.3
 	github.com/sylabs/singularity v0.0.0
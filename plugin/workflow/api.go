@@ -0,0 +1,141 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// An Option sets how Create, Run and Verify handle a workflow.
+type Option func(*workflowOptions)
+
+// WithSigner signs every container that is created, and every output
+// container once it is annotated, with signer.
+func WithSigner(signer signature.Signer) Option {
+	return func(o *workflowOptions) { o.signer = signer }
+}
+
+// WithVerifier checks container signatures with verifier.
+func WithVerifier(verifier signature.Verifier) Option {
+	return func(o *workflowOptions) { o.verifier = verifier }
+}
+
+// WithRequireSigned refuses to run application and input containers that
+// are not signed by the verifier set with WithVerifier.
+func WithRequireSigned() Option {
+	return func(o *workflowOptions) { o.requireSigned = true }
+}
+
+// WithJobs builds up to n containers, or runs up to n stages or sweep runs,
+// at the same time. The default is one.
+func WithJobs(n int) Option {
+	return func(o *workflowOptions) { o.jobs = n }
+}
+
+// WithKeepGoing keeps running the stages that do not depend on a failed
+// stage instead of stopping at the first failure.
+func WithKeepGoing() Option {
+	return func(o *workflowOptions) { o.keepGoing = true }
+}
+
+// WithRebuild rebuilds every container, even those whose sources have not
+// changed since they were built.
+func WithRebuild() Option {
+	return func(o *workflowOptions) { o.rebuild = true }
+}
+
+// WithTempDir stages the files of each container build under dir instead
+// of the system temporary directory.
+func WithTempDir(dir string) Option {
+	return func(o *workflowOptions) { o.tmpDir = dir }
+}
+
+// WithDryRun prints what would be created or run instead of doing it.
+func WithDryRun() Option {
+	return func(o *workflowOptions) { o.dryRun = true }
+}
+
+// WithRuntime builds and runs containers with runtime instead of the
+// apptainer binary.
+func WithRuntime(runtime Runtime) Option {
+	return func(o *workflowOptions) { o.runtime = runtime }
+}
+
+func newOptions(options []Option) (workflowOptions, error) {
+	opts := workflowOptions{jobs: 1}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	if opts.requireSigned && opts.verifier == nil {
+		return opts, fmt.Errorf("requiring signed containers needs a verification key")
+	}
+
+	return opts, nil
+}
+
+// Load reads and validates the workflow description at path.
+func Load(path string) (Workflow, error) {
+	return loadWorkflow(path, false)
+}
+
+// Parse decodes and validates a workflow description.
+func Parse(data []byte) (Workflow, error) {
+	return parseWorkflow(data, "", false)
+}
+
+// Create builds the application, input and output containers of wf in the
+// current directory, printing its progress to standard output. Cancelling
+// ctx stops the builds in progress: the runtime building an application
+// container is passed ctx, and the tools building an input or output
+// container are killed and its partly written image removed.
+func Create(ctx context.Context, wf Workflow, options ...Option) error {
+	opts, err := newOptions(options)
+	if err != nil {
+		return err
+	}
+
+	if problems := wf.validate(true); len(problems) > 0 {
+		return invalidWorkflow(wf.WorkflowName, problems)
+	}
+
+	return wf.createWorkflow(ctx, opts)
+}
+
+// Run runs the stages of wf with the containers in the current directory,
+// and annotates the output containers with the record trail and run record
+// of each run. Cancelling ctx kills the running applications and starts no
+// more.
+func Run(ctx context.Context, wf Workflow, options ...Option) error {
+	opts, err := newOptions(options)
+	if err != nil {
+		return err
+	}
+
+	if problems := wf.validate(false); len(problems) > 0 {
+		return invalidWorkflow(wf.WorkflowName, problems)
+	}
+
+	return wf.run(ctx, opts)
+}
+
+// Verify checks the containers of the workflow description, or of the
+// output container image and its record trail, at path for drift from their
+// metadata and, given WithVerifier, for valid signatures. Cancelling ctx
+// stops the extraction of a squashfs partition being digested.
+func Verify(ctx context.Context, path string, options ...Option) error {
+	opts, err := newOptions(options)
+	if err != nil {
+		return err
+	}
+
+	return verifyWorkflow(ctx, path, opts)
+}
+
+// ReadMetadata returns the metadata of the container image at path, which
+// for an output container holds the record trail of the run that produced
+// it.
+func ReadMetadata(path string) (Metadata, error) {
+	return readContainerMetadata(path)
+}
@@ -0,0 +1,265 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	schedulerSlurm = "slurm"
+	schedulerPBS   = "pbs"
+)
+
+// annotateRequest describes a run made outside the workflow CLI, by a batch job,
+// whose output containers are to be annotated.
+type annotateRequest struct {
+	stage      string
+	exitStatus int
+	startedAt  int64
+	stdoutFile string
+	stderrFile string
+}
+
+// exportWorkflow writes a batch script for scheduler that runs the workflow
+// description at path on a cluster, and returns the script's path. Sweeps
+// become job arrays with one task per parameter combination; the stages of
+// other workflows run one after another in a single job. After every run
+// the script runs the annotate command of cli, the command line of the
+// workflow CLI such as "apptainer workflow", on the output containers.
+func exportWorkflow(path, scheduler, cli string) (string, error) {
+	cfg, err := loadWorkflow(path, false)
+	if err != nil {
+		return "", err
+	}
+
+	stages, err := cfg.getStages()
+	if err != nil {
+		return "", err
+	}
+
+	script, err := cfg.batchScript(stages, path, scheduler, cli)
+	if err != nil {
+		return "", err
+	}
+
+	scriptPath := cfg.WorkflowName + "." + scheduler + ".sh"
+	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		return "", err
+	}
+
+	return scriptPath, nil
+}
+
+func (cfg Workflow) batchScript(stages []Workflow, path, scheduler, cli string) (string, error) {
+	var cpus int
+	var memory int64
+	for _, stage := range stages {
+		stageCPUs, stageMemory := stage.resources()
+		if stageCPUs > cpus {
+			cpus = stageCPUs
+		}
+		if stageMemory > memory {
+			memory = stageMemory
+		}
+	}
+	memoryMiB := ceilDiv(uint64(memory), 1<<20)
+
+	array := cfg.Sweep != nil && len(stages) > 1
+
+	var b strings.Builder
+	b.WriteString("#!/bin/bash\n")
+	switch scheduler {
+	case schedulerSlurm:
+		fmt.Fprintf(&b, "#SBATCH --job-name=%s\n", cfg.WorkflowName)
+		if array {
+			fmt.Fprintf(&b, "#SBATCH --array=0-%d\n", len(stages)-1)
+			fmt.Fprintf(&b, "#SBATCH --output=%s-%%A_%%a.out\n", cfg.WorkflowName)
+		} else {
+			fmt.Fprintf(&b, "#SBATCH --output=%s-%%j.out\n", cfg.WorkflowName)
+		}
+		if cpus > 0 {
+			fmt.Fprintf(&b, "#SBATCH --cpus-per-task=%d\n", cpus)
+		}
+		if memoryMiB > 0 {
+			fmt.Fprintf(&b, "#SBATCH --mem=%dM\n", memoryMiB)
+		}
+		b.WriteString("\ncd \"${SLURM_SUBMIT_DIR:-.}\" || exit 1\n")
+	case schedulerPBS:
+		fmt.Fprintf(&b, "#PBS -N %s\n", cfg.WorkflowName)
+		b.WriteString("#PBS -j oe\n")
+		if array {
+			fmt.Fprintf(&b, "#PBS -J 0-%d\n", len(stages)-1)
+		}
+		resources := "select=1"
+		if cpus > 0 {
+			resources += fmt.Sprintf(":ncpus=%d", cpus)
+		}
+		if memoryMiB > 0 {
+			resources += fmt.Sprintf(":mem=%dmb", memoryMiB)
+		}
+		fmt.Fprintf(&b, "#PBS -l %s\n", resources)
+		b.WriteString("\ncd \"${PBS_O_WORKDIR:-.}\" || exit 1\n")
+	default:
+		return "", fmt.Errorf("unknown batch scheduler %q, expected %s or %s", scheduler, schedulerSlurm, schedulerPBS)
+	}
+
+	b.WriteString("\nworkflow=" + shellQuote(path) + "\n")
+	b.WriteString("status=0\n")
+
+	taskVar := "SLURM_ARRAY_TASK_ID"
+	if scheduler == schedulerPBS {
+		taskVar = "PBS_ARRAY_INDEX"
+	}
+
+	if array {
+		fmt.Fprintf(&b, "\ncase \"$%s\" in\n", taskVar)
+	}
+	for i, stage := range stages {
+		indent := ""
+		if array {
+			indent = "\t"
+		}
+		body, err := stage.batchRun(indent, cli)
+		if err != nil {
+			return "", err
+		}
+		if array {
+			fmt.Fprintf(&b, "%d)\n%s\t;;\n", i, body)
+			continue
+		}
+		b.WriteString("\n" + body)
+		if i < len(stages)-1 {
+			b.WriteString("[ \"$status\" -eq 0 ] || exit \"$status\"\n")
+		}
+	}
+	if array {
+		fmt.Fprintf(&b, "*)\n\techo \"unknown array index $%s\" >&2\n\texit 1\n\t;;\nesac\n", taskVar)
+	}
+
+	b.WriteString("\nexit \"$status\"\n")
+
+	return b.String(), nil
+}
+
+// batchRun returns the script lines, indented by indent, that run one stage
+// and annotate its output containers, leaving the exit status of the run in
+// $status. The output of the application is kept in files for the
+// annotation and copied to the job's output afterwards.
+func (cfg Workflow) batchRun(indent, cli string) (string, error) {
+	inv, err := cfg.newInvocation()
+	if err != nil {
+		return "", err
+	}
+
+	name := shellQuote(cfg.WorkflowName)
+	stdoutFile := shellQuote(cfg.WorkflowName + ".stdout.log")
+	stderrFile := shellQuote(cfg.WorkflowName + ".stderr.log")
+
+	lines := []string{
+		"echo " + shellQuote("Running: stage "+cfg.WorkflowName),
+		"started=$(date +%s)",
+		"(",
+	}
+	for _, env := range inv.containerEnv() {
+		lines = append(lines, "\texport "+shellQuote(env))
+	}
+	lines = append(lines,
+		"\t"+shellCommand(inv.argv()),
+		") > "+stdoutFile+" 2> "+stderrFile,
+		"status=$?",
+		"cat "+stdoutFile,
+		"cat "+stderrFile+" >&2",
+		cli+" annotate \"$workflow\" --stage "+name+" --exit-status \"$status\" --started-at \"$started\" --stdout-file "+stdoutFile+" --stderr-file "+stderrFile+" || status=1",
+	)
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(indent + line + "\n")
+	}
+
+	return b.String(), nil
+}
+
+// shellQuote quotes str for bash.
+func shellQuote(str string) string {
+	if str != "" && strings.Trim(str, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.,/:=+@%") == "" {
+		return str
+	}
+	return "'" + strings.ReplaceAll(str, "'", `'\''`) + "'"
+}
+
+// annotateWorkflow annotates the output containers of one stage of the
+// workflow description at path after a batch job has run it.
+func annotateWorkflow(path string, req annotateRequest, opts workflowOptions) error {
+	cfg, err := loadWorkflow(path, false)
+	if err != nil {
+		return err
+	}
+
+	stages, err := cfg.getStages()
+	if err != nil {
+		return err
+	}
+
+	for _, stage := range stages {
+		if stage.WorkflowName != req.stage && (req.stage != "" || len(stages) > 1) {
+			continue
+		}
+
+		inv, err := stage.newInvocation()
+		if err != nil {
+			return err
+		}
+
+		record := newRunRecord(inv.argv(), opts.containerRuntime())
+		if req.startedAt > 0 {
+			record.StartTime = time.Unix(req.startedAt, 0)
+		}
+		record.finish(nil)
+		if req.exitStatus != 0 {
+			record.ExitStatus = req.exitStatus
+			record.Error = fmt.Sprintf("exit status %d", req.exitStatus)
+		}
+
+		stdout, err := readCapturedOutput("stdout.log", req.stdoutFile)
+		if err != nil {
+			return err
+		}
+		stderr, err := readCapturedOutput("stderr.log", req.stderrFile)
+		if err != nil {
+			return err
+		}
+
+		return stage.annotateOutputs(context.Background(), inv, record, stdout, stderr, opts)
+	}
+
+	return fmt.Errorf("workflow has no stage %q", req.stage)
+}
+
+// readCapturedOutput captures the contents of the file at path, which may
+// be empty or missing.
+func readCapturedOutput(object, path string) (*capturedOutput, error) {
+	captured := newCapturedOutput(object, outputCaptureLimit)
+	if path == "" {
+		return captured, nil
+	}
+
+	file, err := os.Open(filepath.Clean(path))
+	if os.IsNotExist(err) {
+		return captured, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(captured, file); err != nil {
+		return nil, err
+	}
+
+	return captured, nil
+}
@@ -0,0 +1,106 @@
+package workflow
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestBatchScript(t *testing.T) {
+	app := Container{Name: "app", InPath: "app.def"}
+	data := Container{Name: "data", InPath: "data"}
+
+	workflows := map[string]Workflow{
+		"single": {
+			WorkflowName:         "single",
+			ApplicationContainer: app,
+			InputContainer:       []Container{data},
+			OutputContainer:      Container{Name: "results", Size: 4 << 20},
+			Args:                 []string{"--input", "/data/data", "--label", "two words"},
+			Env:                  map[string]string{"THREADS": "4", "MODE": "fast"},
+			Resources:            &Resources{CPUs: 4, Memory: 2 << 30},
+		},
+		"stages": {
+			WorkflowName: "stages",
+			Resources:    &Resources{CPUs: 2},
+			Stages: []Stage{
+				{
+					Name:                 "prepare",
+					ApplicationContainer: app,
+					InputContainer:       []Container{data},
+					OutputContainer:      Container{Name: "prepared", Size: 4 << 20},
+					Args:                 []string{"prepare"},
+				},
+				{
+					Name:                 "analyse",
+					ApplicationContainer: app,
+					InputContainer:       []Container{{Stage: "prepare"}},
+					OutputContainer:      Container{Name: "results", Size: 4 << 20},
+					Args:                 []string{"analyse"},
+					Resources:            &Resources{CPUs: 8, Memory: 512 << 20},
+				},
+			},
+		},
+		"sweep": {
+			WorkflowName:         "sweep",
+			ApplicationContainer: app,
+			InputContainer:       []Container{data},
+			OutputContainer:      Container{Name: "predictions", Size: 4 << 20},
+			Args:                 []string{"--k", "{k}", "--model", "{model}"},
+			Sweep: &Sweep{Grid: map[string][]SweepValue{
+				"k":     {"1", "8"},
+				"model": {"knn"},
+			}},
+		},
+	}
+
+	for name, wf := range workflows {
+		for _, scheduler := range []string{schedulerSlurm, schedulerPBS} {
+			name, wf, scheduler := name, wf, scheduler
+			t.Run(name+"/"+scheduler, func(t *testing.T) {
+				stages, err := wf.getStages()
+				if err != nil {
+					t.Fatal(err)
+				}
+				script, err := wf.batchScript(stages, name+".json", scheduler, "apptainer workflow")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				golden := filepath.Join("testdata", name+"."+scheduler+".sh")
+				if *update {
+					if err := os.WriteFile(golden, []byte(script), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if script != string(want) {
+					t.Errorf("script differs from %s, rerun with -update to accept it:\n%s", golden, script)
+				}
+			})
+		}
+	}
+}
+
+func TestBatchScriptUnknownScheduler(t *testing.T) {
+	wf := Workflow{
+		WorkflowName:         "single",
+		ApplicationContainer: Container{Name: "app"},
+		OutputContainer:      Container{Name: "results"},
+	}
+	stages, err := wf.getStages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := wf.batchScript(stages, "single.json", "lsf", "apptainer workflow"); err == nil {
+		t.Error("script written for an unknown scheduler")
+	}
+}
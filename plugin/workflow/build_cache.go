@@ -0,0 +1,91 @@
+package workflow
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A build key identifies the sources of a container: the def file and its
+// %files sources for an application container, the InPath tree and
+// partition settings for an input container. It is stored in the container
+// metadata so that an unchanged container need not be rebuilt.
+
+// appBuildKey hashes the def file at InPath and every file it copies into
+// the container from the host.
+func (cfg Container) appBuildKey() (string, error) {
+	def, err := os.ReadFile(cfg.InPath)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "app\x00%s\x00", def)
+
+	for _, source := range defFileSources(string(def)) {
+		matches, err := filepath.Glob(source)
+		if err != nil || len(matches) == 0 {
+			// apptainer build reports the missing source
+			fmt.Fprintf(h, "%s\x00missing\x00", source)
+			continue
+		}
+		for _, match := range matches {
+			files, err := hostFileDigests(match)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s\x00%s\x00", match, newContentDigest(files).MerkleRoot)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// inputBuildKey hashes the files under InPath and the settings that shape
+// the input container's partition.
+func (cfg Container) inputBuildKey() (string, error) {
+	files, err := hostFileDigests(cfg.InPath)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "input\x00%s\x00%s\x00%d\x00%s\x00%s\x00%s", cfg.Name, filepath.Base(cfg.InPath), cfg.Size, cfg.Headroom, cfg.Format, newContentDigest(files).MerkleRoot)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// defFileSources returns the host paths listed in the %files sections of a
+// def file.
+func defFileSources(def string) []string {
+	var sources []string
+	inFiles := false
+
+	scanner := bufio.NewScanner(strings.NewReader(def))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "%") {
+			fields := strings.Fields(line)
+			// sources copied from another build stage are not on the host
+			inFiles = fields[0] == "%files" && len(fields) == 1
+			continue
+		}
+		if !inFiles || line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sources = append(sources, strings.Fields(line)[0])
+	}
+
+	return sources
+}
+
+// upToDate reports whether the container image name.sif exists and was
+// built from sources with the given build key.
+func upToDate(name, buildKey string) bool {
+	metadata, err := readContainerMetadata(name + ".sif")
+	return err == nil && metadata.BuildKey != "" && metadata.BuildKey == buildKey
+}
@@ -0,0 +1,368 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// optionFlags holds the values of the flags that set workflowOptions. Each
+// command registers only the flags it uses.
+type optionFlags struct {
+	signKey       string
+	verifyKey     string
+	requireSigned bool
+	jobs          int
+	keepGoing     bool
+	rebuild       bool
+	tmpDir        string
+	dryRun        bool
+}
+
+func (f *optionFlags) register(cmd *cobra.Command, names ...string) {
+	flags := cmd.Flags()
+	for _, name := range names {
+		switch name {
+		case "sign-key":
+			flags.StringVar(&f.signKey, name, "", "PEM encoded private key used to sign the containers that are created or annotated")
+		case "verify-key":
+			flags.StringVar(&f.verifyKey, name, "", "PEM encoded public key used to check container signatures")
+		case "require-signed":
+			flags.BoolVar(&f.requireSigned, name, false, "Refuse to run application and input containers that are not signed by the --verify-key")
+		case "jobs":
+			flags.IntVarP(&f.jobs, name, "j", 1, "Number of containers to build, or of independent stages or sweep runs to run, at the same time")
+		case "keep-going":
+			flags.BoolVar(&f.keepGoing, name, false, "Keep running stages that do not depend on a failed stage instead of stopping at the first failure")
+		case "rebuild":
+			flags.BoolVar(&f.rebuild, name, false, "Rebuild every container, even those whose sources have not changed")
+		case "tmpdir":
+			flags.StringVar(&f.tmpDir, name, "", "Directory, such as node-local scratch, under which each container build stages its files (default $TMPDIR or /tmp)")
+		case "dry-run":
+			flags.BoolVar(&f.dryRun, name, false, "Check the workflow description and print the containers, commands and files it would create or run, without writing anything")
+		}
+	}
+}
+
+func (f *optionFlags) options() (workflowOptions, error) {
+	opts, err := newWorkflowOptions(f.signKey, f.verifyKey, f.requireSigned)
+	if err != nil {
+		return opts, err
+	}
+	opts.jobs = f.jobs
+	opts.keepGoing = f.keepGoing
+	opts.rebuild = f.rebuild
+	opts.tmpDir = f.tmpDir
+	opts.dryRun = f.dryRun
+
+	return opts, nil
+}
+
+// Commands returns the subcommands of the workflow CLI. cli is the command
+// line that runs them, such as "apptainer workflow" for the plugin or "tric"
+// for the standalone binary, which exported batch scripts call back into.
+func Commands(cli string) []*cobra.Command {
+	return []*cobra.Command{
+		newCreateCmd(),
+		newWebCmd(),
+		newRunCmd(),
+		newVerifyCmd(),
+		newInspectCmd(),
+		newExportCmd(cli),
+		newAnnotateCmd(),
+		newTemplateCmd(),
+	}
+}
+
+// PluginCommand returns the workflow command of the Apptainer plugin, which
+// still accepts the flags that selected an operation before there were
+// subcommands.
+func PluginCommand() *cobra.Command {
+	var f optionFlags
+	var createFlag, runFlag, verifyFlag bool
+
+	workflowCmd := &cobra.Command{
+		Use:   "workflow",
+		Short: "Use the 'workflow' subcommands to create and run workflows",
+		Long:  "Use the 'workflow' subcommands to create, run, verify, inspect and export workflows",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !createFlag && !runFlag && !verifyFlag {
+				return cmd.Help()
+			}
+
+			opts, err := f.options()
+			if err != nil {
+				return err
+			}
+
+			defer handleInterrupts()()
+			return workflowEntryPoint(createFlag, runFlag, verifyFlag, args, opts)
+		},
+	}
+
+	workflowCmd.Flags().BoolVarP(&createFlag, "create", "c", false, "Create a workflow")
+	workflowCmd.Flags().BoolVarP(&runFlag, "run", "r", false, "Run a workflow")
+	workflowCmd.Flags().BoolVar(&verifyFlag, "verify", false, "Verify a workflow")
+	f.register(workflowCmd, "sign-key", "verify-key", "require-signed", "jobs", "keep-going", "rebuild", "tmpdir", "dry-run")
+
+	deprecated := map[string]string{
+		"create": "use 'apptainer workflow create' or 'apptainer workflow web' instead",
+		"run":    "use 'apptainer workflow run' instead",
+		"verify": "use 'apptainer workflow verify' instead",
+	}
+	for name, message := range deprecated {
+		workflowCmd.Flags().MarkDeprecated(name, message)
+	}
+	for _, name := range []string{"sign-key", "verify-key", "require-signed", "jobs", "keep-going", "rebuild", "tmpdir", "dry-run"} {
+		workflowCmd.Flags().MarkDeprecated(name, "use it with a 'apptainer workflow' subcommand instead")
+	}
+
+	return workflowCmd
+}
+
+func newCreateCmd() *cobra.Command {
+	var f optionFlags
+
+	cmd := &cobra.Command{
+		Use:   "create <workflow.json>",
+		Short: "Build the containers of a workflow",
+		Long:  "Build the application, input and output containers of the workflow described by a JSON file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts, err := f.options()
+			if err != nil {
+				return err
+			}
+
+			defer handleInterrupts()()
+			if err := workflowCreateJSON(args[0], opts); err != nil {
+				return fmt.Errorf("Unable to create workflow from JSON file: %s: %v", args[0], err)
+			}
+			return nil
+		},
+	}
+	f.register(cmd, "sign-key", "jobs", "rebuild", "tmpdir", "dry-run")
+
+	return cmd
+}
+
+func newWebCmd() *cobra.Command {
+	var f optionFlags
+
+	cmd := &cobra.Command{
+		Use:   "web",
+		Short: "Describe and create a workflow in the web interface",
+		Long:  "Serve the web interface on localhost:8080 to describe a workflow, then create it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts, err := f.options()
+			if err != nil {
+				return err
+			}
+
+			defer handleInterrupts()()
+			if err := workflowCreateWeb(opts); err != nil {
+				return fmt.Errorf("Unable to create workflow from web interface: %v", err)
+			}
+			return nil
+		},
+	}
+	f.register(cmd, "sign-key", "tmpdir")
+
+	return cmd
+}
+
+func newRunCmd() *cobra.Command {
+	var f optionFlags
+
+	cmd := &cobra.Command{
+		Use:   "run <workflow.json>",
+		Short: "Run a workflow",
+		Long:  "Run the stages of the workflow described by a JSON file and annotate their output containers",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts, err := f.options()
+			if err != nil {
+				return err
+			}
+
+			defer handleInterrupts()()
+			if err := execWorkflow(args[0], opts); err != nil {
+				return fmt.Errorf("Could not execute workflow: %v", err)
+			}
+			return nil
+		},
+	}
+	f.register(cmd, "sign-key", "verify-key", "require-signed", "jobs", "keep-going", "dry-run")
+
+	return cmd
+}
+
+func newVerifyCmd() *cobra.Command {
+	var f optionFlags
+
+	cmd := &cobra.Command{
+		Use:   "verify <workflow.json | output.sif>",
+		Short: "Check the containers of a workflow for tampering",
+		Long:  "Check the containers of a workflow description, or an output container image and every container in its record trail, for drift from their metadata and invalid signatures",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts, err := f.options()
+			if err != nil {
+				return err
+			}
+
+			if err := verifyWorkflow(context.Background(), args[0], opts); err != nil {
+				return fmt.Errorf("Workflow verification failed: %v", err)
+			}
+			return nil
+		},
+	}
+	f.register(cmd, "verify-key")
+
+	return cmd
+}
+
+func newInspectCmd() *cobra.Command {
+	var object string
+
+	cmd := &cobra.Command{
+		Use:   "inspect <container.sif>",
+		Short: "Print the metadata of a workflow container",
+		Long:  "Print the metadata recorded in a workflow container image, or one of the stdout.log and stderr.log outputs captured in it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := inspectContainer(args[0], object); err != nil {
+				return fmt.Errorf("Could not inspect container: %v", err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&object, "log", "", "Print the captured output stdout or stderr instead of the metadata")
+
+	return cmd
+}
+
+func newExportCmd(cli string) *cobra.Command {
+	var scheduler string
+
+	cmd := &cobra.Command{
+		Use:   "export <workflow.json>",
+		Short: "Write a batch script that runs a workflow on a cluster",
+		Long:  "Write a Slurm or PBS batch script that runs the workflow described by a JSON file and annotates its output containers",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scriptPath, err := exportWorkflow(args[0], scheduler, cli)
+			if err != nil {
+				return fmt.Errorf("Could not export workflow: %v", err)
+			}
+			fmt.Fprintf(os.Stdout, "Batch script: %s\n", scriptPath)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&scheduler, "scheduler", schedulerSlurm, "Scheduler the batch script is written for, slurm or pbs")
+
+	return cmd
+}
+
+// newAnnotateCmd returns the command that exported batch scripts use to
+// annotate the output containers of the runs they make.
+func newAnnotateCmd() *cobra.Command {
+	var f optionFlags
+	var req annotateRequest
+
+	cmd := &cobra.Command{
+		Use:    "annotate <workflow.json>",
+		Short:  "Annotate the output containers of a stage run by a batch job",
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts, err := f.options()
+			if err != nil {
+				return err
+			}
+
+			if err := annotateWorkflow(args[0], req, opts); err != nil {
+				return fmt.Errorf("Could not annotate workflow: %v", err)
+			}
+			return nil
+		},
+	}
+	f.register(cmd, "sign-key")
+	cmd.Flags().StringVar(&req.stage, "stage", "", "Stage whose output containers are annotated")
+	cmd.Flags().IntVar(&req.exitStatus, "exit-status", 0, "Exit status of the annotated run")
+	cmd.Flags().Int64Var(&req.startedAt, "started-at", 0, "Start time of the annotated run, in seconds since the epoch")
+	cmd.Flags().StringVar(&req.stdoutFile, "stdout-file", "", "File holding the standard output of the annotated run")
+	cmd.Flags().StringVar(&req.stderrFile, "stderr-file", "", "File holding the standard error of the annotated run")
+
+	return cmd
+}
+
+func newTemplateCmd() *cobra.Command {
+	var templateName, outputDir string
+	var params, templateDirs []string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "new",
+		Short: "Create a workflow description from a template",
+		Long:  "Create a workflow description and its def files from a template, or list the templates when no --template is given",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := newWorkflow(templateName, params, outputDir, templateDirs, force); err != nil {
+				return fmt.Errorf("Unable to create workflow from template: %v", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&templateName, "template", "t", "", "Name of the template to create the workflow from")
+	cmd.Flags().StringArrayVarP(&params, "param", "p", nil, "Template parameter, as name=value; may be repeated")
+	cmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Directory to write the workflow files to")
+	cmd.Flags().StringArrayVar(&templateDirs, "template-dir", nil, "Directory of user-defined templates, searched before $"+templateDirsEnv+" and the built-in templates; may be repeated")
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite existing files")
+
+	return cmd
+}
+
+// workflowEntryPoint runs the operation selected by the deprecated flags of
+// the workflow command.
+func workflowEntryPoint(createFlag, runFlag, verifyFlag bool, args []string, opts workflowOptions) error {
+	flags := 0
+	for _, flag := range []bool{createFlag, runFlag, verifyFlag} {
+		if flag {
+			flags++
+		}
+	}
+
+	if flags > 1 {
+		return fmt.Errorf("Can only create, run or verify a workflow one at a time")
+	} else if createFlag {
+		if len(args) > 0 {
+			if err := workflowCreateJSON(args[0], opts); err != nil {
+				return fmt.Errorf("Unable to create workflow from JSON file: %s: %v", args[0], err)
+			}
+		} else {
+			if err := workflowCreateWeb(opts); err != nil {
+				return fmt.Errorf("Unable to create workflow from web interface: %v", err)
+			}
+		}
+	} else if runFlag {
+		if len(args) == 0 {
+			return fmt.Errorf("Cannot execute workflow without workflow description")
+		}
+		if err := execWorkflow(args[0], opts); err != nil {
+			return fmt.Errorf("Could not execute workflow: %v", err)
+		}
+	} else if verifyFlag {
+		if len(args) == 0 {
+			return fmt.Errorf("Cannot verify workflow without workflow description or output container")
+		}
+		if err := verifyWorkflow(context.Background(), args[0], opts); err != nil {
+			return fmt.Errorf("Workflow verification failed: %v", err)
+		}
+	}
+
+	return nil
+}
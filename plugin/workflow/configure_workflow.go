@@ -1,6 +1,7 @@
-package main
+package workflow
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,28 +10,26 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
-func workflowCreateJSON(path string) error {
-	file, err := os.ReadFile(path)
+func workflowCreateJSON(path string, opts workflowOptions) error {
+	cfg, err := loadWorkflow(path, true)
 	if err != nil {
 		return err
 	}
 
-	var cfg workflowConfig
-	if err := json.Unmarshal(file, &cfg); err != nil {
-		return err
-	}
-
-	if err := cfg.createWorkflow(); err != nil {
+	if err := cfg.createWorkflow(context.Background(), opts); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func postContainerConfig(w http.ResponseWriter, r *http.Request) {
+// postContainerConfig creates the workflow described by the web form with
+// opts.
+func postContainerConfig(w http.ResponseWriter, r *http.Request, opts workflowOptions) {
 	if err := r.ParseForm(); err != nil {
 		log.Printf("ParseForm() err: %v", err)
 		return
@@ -42,6 +41,11 @@ func postContainerConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if problems := cfg.validate(true); len(problems) > 0 {
+		log.Printf("invalid workflow description:\n\t%s", strings.Join(problems, "\n\t"))
+		return
+	}
+
 	JSON, err := json.Marshal(cfg)
 	if err != nil {
 		log.Println(err)
@@ -54,7 +58,7 @@ func postContainerConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := cfg.createWorkflow(); err != nil {
+	if err := cfg.createWorkflow(context.Background(), opts); err != nil {
 		log.Println(err)
 		return
 	}
@@ -70,7 +74,9 @@ func postContainerConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func workflowCreateWeb() error {
+// workflowCreateWeb serves the web interface and creates each workflow
+// described in it with opts.
+func workflowCreateWeb(opts workflowOptions) error {
 	server := &http.Server{
 		Addr:    ":5000",
 		Handler: nil,
@@ -87,7 +93,7 @@ func workflowCreateWeb() error {
 			}
 		}
 	})
-	http.HandleFunc("/post", postContainerConfig)
+	http.HandleFunc("/post", func(rw http.ResponseWriter, r *http.Request) { postContainerConfig(rw, r, opts) })
 	http.HandleFunc("/quit", func(rw http.ResponseWriter, r *http.Request) { server.Close() })
 
 	fmt.Println("Navigate to 'localhost:5000' to setup your workflow")
@@ -101,8 +107,8 @@ func workflowCreateWeb() error {
 	return nil
 }
 
-func parseWebContainerConfig(formData map[string][]string) (workflowConfig, error) {
-	var cfg workflowConfig
+func parseWebContainerConfig(formData map[string][]string) (Workflow, error) {
+	var cfg Workflow
 
 	pow := func(n, m int64) int64 {
 		if m == 0 {
@@ -163,10 +169,10 @@ func parseWebContainerConfig(formData map[string][]string) (workflowConfig, erro
 				return cfg, fmt.Errorf("error parsing input containers")
 			}
 			size *= pow(1024, sizeUnit)
-			cfg.InputContainer = append(cfg.InputContainer, containerConfig{
+			cfg.InputContainer = append(cfg.InputContainer, Container{
 				Name:   inName[i],
 				InPath: ininPath[i],
-				Size:   size,
+				Size:   Size(size),
 			})
 		}
 	} else {
@@ -197,7 +203,7 @@ func parseWebContainerConfig(formData map[string][]string) (workflowConfig, erro
 			return cfg, fmt.Errorf("error parsing output container")
 		}
 		size *= pow(1024, sizeUnit)
-		cfg.OutputContainer.Size = size
+		cfg.OutputContainer.Size = Size(size)
 	} else {
 		return cfg, fmt.Errorf("error parsing output container")
 	}
@@ -0,0 +1,277 @@
+package workflow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"github.com/apptainer/sif/v2/pkg/sif"
+)
+
+// ContentDigest identifies the contents of the data partitions of a
+// container independently of its UUID and creation time. MerkleRoot is the
+// root of a binary hash tree whose leaves are the Files in path order, so
+// containers holding identical files have identical roots.
+type ContentDigest struct {
+	Algorithm  string
+	MerkleRoot string
+	Files      []FileDigest
+}
+
+// FileDigest is the digest of one file of a data partition.
+type FileDigest struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// getContentDigest computes the content digest of the data partitions of
+// the container image at path. It returns nil if the image has none. A
+// squashfs partition is extracted under scratch, if set, and unsquashfs is
+// killed if ctx is done first.
+func getContentDigest(ctx context.Context, path, scratch string) (*ContentDigest, error) {
+	img, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return nil, err
+	}
+
+	descriptors, err := img.GetDescriptors(sif.WithPartitionType(sif.PartData))
+	if err != nil {
+		img.UnloadContainer()
+		return nil, fmt.Errorf("could not retrieve data partitions: %v", err)
+	}
+
+	if err := img.UnloadContainer(); err != nil {
+		return nil, err
+	}
+
+	if len(descriptors) == 0 {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var files []FileDigest
+	for _, descriptor := range descriptors {
+		fsType, _, _, err := descriptor.PartitionMetadata()
+		if err != nil {
+			return nil, err
+		}
+
+		partition := io.NewSectionReader(file, descriptor.Offset(), descriptor.Size())
+		var partitionFiles []FileDigest
+		switch fsType {
+		case sif.FsExt3:
+			partitionFiles, err = ext3FileDigests(partition)
+		case sif.FsSquash:
+			partitionFiles, err = squashfsFileDigests(ctx, partition, scratch)
+		default:
+			err = fmt.Errorf("unsupported filesystem type %v", fsType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error digesting partition %s: %v", descriptor.Name(), err)
+		}
+		files = append(files, partitionFiles...)
+	}
+
+	return newContentDigest(files), nil
+}
+
+// getImageDigest computes a digest of the objects of the container image at
+// path other than its metadata and signatures, such as the definition file
+// and root filesystem of an application container, which has no data
+// partition. Each object is a leaf named by its ID and data type.
+func getImageDigest(path string) (*ContentDigest, error) {
+	img, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return nil, err
+	}
+	defer img.UnloadContainer()
+
+	descriptors, err := img.GetDescriptors(func(d sif.Descriptor) (bool, error) {
+		switch d.DataType() {
+		case sif.DataGenericJSON, sif.DataSignature:
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve objects: %v", err)
+	}
+
+	var objects []FileDigest
+	for _, descriptor := range descriptors {
+		h := sha256.New()
+		if _, err := io.Copy(h, descriptor.GetReader()); err != nil {
+			return nil, fmt.Errorf("error reading object %d: %v", descriptor.ID(), err)
+		}
+		objects = append(objects, FileDigest{
+			Path:   fmt.Sprintf("%d/%v", descriptor.ID(), descriptor.DataType()),
+			Size:   descriptor.Size(),
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+
+	return newContentDigest(objects), nil
+}
+
+func newContentDigest(files []FileDigest) *ContentDigest {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	return &ContentDigest{
+		Algorithm:  "sha256",
+		MerkleRoot: merkleRoot(files),
+		Files:      files,
+	}
+}
+
+// merkleRoot hashes each file as a leaf, H(0x00 || path || 0x00 || digest),
+// and each pair of nodes as H(0x01 || left || right), promoting an odd node
+// to the next level unchanged.
+func merkleRoot(files []FileDigest) string {
+	level := make([][]byte, 0, len(files))
+	for _, file := range files {
+		h := sha256.New()
+		h.Write([]byte{0})
+		h.Write([]byte(file.Path))
+		h.Write([]byte{0})
+		h.Write([]byte(file.SHA256))
+		level = append(level, h.Sum(nil))
+	}
+
+	if len(level) == 0 {
+		empty := sha256.Sum256(nil)
+		return hex.EncodeToString(empty[:])
+	}
+
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write([]byte{1})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+
+	return hex.EncodeToString(level[0])
+}
+
+func ext3FileDigests(partition io.ReaderAt) ([]FileDigest, error) {
+	fsReader, err := openExt3(partition)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileDigest
+	err = fsReader.walk(func(file ext3File) error {
+		if file.mode&ext3ModeMask != ext3ModeRegular {
+			return nil
+		}
+		h := sha256.New()
+		if err := fsReader.copyFile(h, file.inode); err != nil {
+			return fmt.Errorf("error reading %s: %v", file.path, err)
+		}
+		files = append(files, FileDigest{
+			Path:   file.path,
+			Size:   file.size,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+		return nil
+	})
+
+	return files, err
+}
+
+// squashfsFileDigests extracts the squashfs partition with unsquashfs into
+// a directory of its own under scratch and digests the extracted files.
+func squashfsFileDigests(ctx context.Context, partition io.Reader, scratch string) ([]FileDigest, error) {
+	tmpDir, cleanup, err := newScratchDir(scratch, "tric_squashfs_")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	imagePath := filepath.Join(tmpDir, "partition.squashfs")
+	image, err := os.Create(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(image, partition); err != nil {
+		image.Close()
+		return nil, err
+	}
+	if err := image.Close(); err != nil {
+		return nil, err
+	}
+
+	rootDir := filepath.Join(tmpDir, "root")
+	if out, err := exec.CommandContext(
+		ctx,
+		"unsquashfs",
+		"-no-progress",
+		"-no-xattrs",
+		"-d",
+		rootDir,
+		imagePath,
+	).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("error extracting squashfs filesystem: %v: %s", err, out)
+	}
+
+	return hostFileDigests(rootDir)
+}
+
+// hostFileDigests digests the regular files below the host directory root.
+func hostFileDigests(root string) ([]FileDigest, error) {
+	var files []FileDigest
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		h := sha256.New()
+		size, err := io.Copy(h, file)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, FileDigest{
+			Path:   filepath.ToSlash(rel),
+			Size:   size,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+		return nil
+	})
+
+	return files, err
+}
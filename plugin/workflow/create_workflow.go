@@ -0,0 +1,509 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/apptainer/sif/v2/pkg/sif"
+	uuid "github.com/satori/go.uuid"
+)
+
+// createWorkflow builds the containers of the workflow. Cancelling ctx stops
+// the builds in progress and skips those not yet started.
+func (cfg Workflow) createWorkflow(ctx context.Context, opts workflowOptions) error {
+	if opts.dryRun {
+		return cfg.planCreate(opts)
+	}
+
+	stages, err := cfg.getStages()
+	if err != nil {
+		return err
+	}
+
+	var tasks []buildTask
+	seen := make(map[string]bool)
+	for _, stage := range stages {
+		if cfg.multiStage() {
+			fmt.Fprintf(os.Stdout, "Stage: %s\n", stage.WorkflowName)
+		}
+		tasks = append(tasks, stage.buildTasks(ctx, seen, opts)...)
+	}
+
+	if err := runBuildTasks(ctx, tasks, opts.jobs); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stdout, "workflow has been set up")
+
+	return nil
+}
+
+// buildTask builds one container. say prints a line of progress output.
+type buildTask struct {
+	name  string
+	build func(say func(format string, a ...interface{})) error
+}
+
+// runBuildTasks runs the tasks, at most jobs at a time, prefixing the
+// progress output of each with its position in the list. Every task is run
+// even if some fail, and the failures are reported together. Once ctx is
+// done, the tasks not yet started fail with its error.
+func runBuildTasks(ctx context.Context, tasks []buildTask, jobs int) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make([]error, len(tasks))
+	slots := make(chan struct{}, jobs)
+
+	for i, task := range tasks {
+		slots <- struct{}{}
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			<-slots
+			continue
+		}
+		wg.Add(1)
+		go func(i int, task buildTask) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			prefix := fmt.Sprintf("[%d/%d] ", i+1, len(tasks))
+			say := func(format string, a ...interface{}) {
+				mu.Lock()
+				defer mu.Unlock()
+				fmt.Fprintf(os.Stdout, prefix+format+"\n", a...)
+			}
+			errs[i] = task.build(say)
+		}(i, task)
+	}
+	wg.Wait()
+
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", tasks[i].name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d container builds failed:\n\t%s", len(failures), len(tasks), strings.Join(failures, "\n\t"))
+	}
+
+	return nil
+}
+
+// buildTasks returns the tasks that build the containers of a single stage,
+// skipping any container already in seen. The task of an input provided by
+// an upstream stage only reports that it is skipped. Unless opts.rebuild is set, the tasks reuse any
+// application or input container whose sources have not changed since it
+// was built.
+func (cfg Workflow) buildTasks(ctx context.Context, seen map[string]bool, opts workflowOptions) []buildTask {
+	var tasks []buildTask
+
+	// application container
+	if app := cfg.ApplicationContainer; !seen[app.Name] {
+		seen[app.Name] = true
+		tasks = append(tasks, buildTask{
+			name: app.Name,
+			build: func(say func(string, ...interface{})) error {
+				buildKey, err := app.appBuildKey()
+				if err != nil {
+					return fmt.Errorf("error hashing sources of application container %s: %v", app.Name, err)
+				}
+				if !opts.rebuild && upToDate(app.Name, buildKey) {
+					say("Unchanged: application container %s", app.Name)
+				} else {
+					say("Building: application container %s", app.Name)
+					if err := app.buildAppContainer(ctx, buildKey, opts.tmpDir, opts.containerRuntime()); err != nil {
+						return fmt.Errorf("error creating application container %s: %v", app.Name, err)
+					}
+				}
+				if err := opts.signContainer(app.Name); err != nil {
+					return fmt.Errorf("error signing application container %s: %v", app.Name, err)
+				}
+				say("Completed: application container %s", app.Name)
+				return nil
+			},
+		})
+	}
+
+	// input containers
+	for i, inputContainer := range cfg.InputContainer {
+		i, inputContainer := i, inputContainer
+		if inputContainer.Stage != "" {
+			// nothing to build, but the line is numbered and kept whole
+			// among the output of the concurrent builds like any other
+			tasks = append(tasks, buildTask{
+				name: inputContainer.Name,
+				build: func(say func(string, ...interface{})) error {
+					say("Skipping: input container %d: %s is produced by stage %s", i+1, inputContainer.Name, inputContainer.Stage)
+					return nil
+				},
+			})
+			continue
+		}
+		if seen[inputContainer.Name] {
+			continue
+		}
+		seen[inputContainer.Name] = true
+		tasks = append(tasks, buildTask{
+			name: inputContainer.Name,
+			build: func(say func(string, ...interface{})) error {
+				buildKey, err := inputContainer.inputBuildKey()
+				if err != nil {
+					return fmt.Errorf("error hashing sources of input container %s: %v", inputContainer.Name, err)
+				}
+				if !opts.rebuild && upToDate(inputContainer.Name, buildKey) {
+					say("Unchanged: input container %d: %s", i+1, inputContainer.Name)
+				} else {
+					say("Building: input container %d: %s", i+1, inputContainer.Name)
+					if err := inputContainer.createInputContainer(ctx, buildKey, opts.tmpDir, say); err != nil {
+						return fmt.Errorf("error creating input container %d: %s: %v", i+1, inputContainer.Name, err)
+					}
+				}
+				if err := opts.signContainer(inputContainer.Name); err != nil {
+					return fmt.Errorf("error signing input container %s: %v", inputContainer.Name, err)
+				}
+				say("Completed: input container %d: %s", i+1, inputContainer.Name)
+				return nil
+			},
+		})
+	}
+
+	// output containers
+	for _, outputContainer := range cfg.outputContainers() {
+		outputContainer := outputContainer
+		if seen[outputContainer.Name] {
+			continue
+		}
+		seen[outputContainer.Name] = true
+		tasks = append(tasks, buildTask{
+			name: outputContainer.Name,
+			build: func(say func(string, ...interface{})) error {
+				say("Building: output container %s", outputContainer.Name)
+				if err := outputContainer.createOutputContainer(ctx, opts.tmpDir); err != nil {
+					return fmt.Errorf("error creating output container %s: %v", outputContainer.Name, err)
+				}
+				if err := opts.signContainer(outputContainer.Name); err != nil {
+					return fmt.Errorf("error signing output container %s: %v", outputContainer.Name, err)
+				}
+				say("Completed: output container %s", outputContainer.Name)
+				return nil
+			},
+		})
+	}
+
+	return tasks
+}
+
+// outputContainers returns every output container of the workflow: the
+// OutputContainer, when named, followed by the OutputContainers list.
+func (cfg Workflow) outputContainers() []Container {
+	return joinOutputContainers(cfg.OutputContainer, cfg.OutputContainers)
+}
+
+func (stage Stage) outputContainers() []Container {
+	return joinOutputContainers(stage.OutputContainer, stage.OutputContainers)
+}
+
+func joinOutputContainers(outputContainer Container, outputContainers []Container) []Container {
+	joined := make([]Container, 0, len(outputContainers)+1)
+	if outputContainer.Name != "" {
+		joined = append(joined, outputContainer)
+	}
+	return append(joined, outputContainers...)
+}
+
+// buildAppContainer builds the application container from its def file
+// with runtime, letting it keep its temporary files under scratch, if set.
+// The build is stopped if ctx is done first.
+func (cfg Container) buildAppContainer(ctx context.Context, buildKey, scratch string, runtime Runtime) error {
+	if err := runtime.Build(ctx, cfg.Name+".sif", cfg.InPath, scratch); err != nil {
+		return fmt.Errorf("error building application container %v", err)
+	}
+
+	if err := addStaticMetadata(ctx, cfg.Name, false, buildKey, scratch); err != nil {
+		return fmt.Errorf("error adding static metadata to application container: %v", err)
+	}
+
+	return nil
+}
+
+// createInputContainer builds the input container, staging its files under
+// scratch and reporting its size through say when it is sized
+// automatically. The build is stopped if ctx is done first, and a partly
+// written image is removed on failure.
+func (cfg Container) createInputContainer(ctx context.Context, buildKey, scratch string, say func(string, ...interface{})) error {
+	var inputPartition io.Reader
+	var fsType sif.FSType
+
+	switch cfg.Format {
+	case "", formatExt3:
+		size, err := cfg.partitionBytes(cfg.InPath)
+		if err != nil {
+			return fmt.Errorf("error sizing input container: %v", err)
+		}
+		if cfg.Size != Size(size) {
+			say("Sizing: input container %s to %d bytes", cfg.Name, size)
+			cfg.Size = Size(size)
+		}
+
+		inputPartition, err = cfg.createExt3Partition(ctx, cfg.InPath, scratch)
+		if err != nil {
+			return fmt.Errorf("error creating input filesystem: %v", err)
+		}
+		fsType = sif.FsExt3
+	case formatSquashfs:
+		var err error
+		inputPartition, err = cfg.createSquashfsPartition(ctx, cfg.InPath, scratch)
+		if err != nil {
+			return fmt.Errorf("error creating input filesystem: %v", err)
+		}
+		fsType = sif.FsSquash
+	default:
+		return fmt.Errorf("unknown input container format %q, expected %q or %q", cfg.Format, formatExt3, formatSquashfs)
+	}
+
+	inputSifDesc, err := sif.NewDescriptorInput(sif.DataPartition, contextReader{ctx, inputPartition}, sif.OptObjectName(cfg.Name), sif.OptPartitionMetadata(fsType, sif.PartData, "amd64"))
+	if err != nil {
+		return err
+	}
+
+	inputContainerUUID := uuid.NewV4()
+
+	path := cfg.Name + ".sif"
+	defer removeOnInterrupt(path)()
+
+	inputContainerImg, err := sif.CreateContainerAtPath(path, sif.OptCreateWithID(inputContainerUUID.String()), sif.OptCreateWithDescriptors(inputSifDesc))
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error creating input container image: %v", err)
+	}
+
+	if err := inputContainerImg.UnloadContainer(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	if err := addStaticMetadata(ctx, cfg.Name, true, buildKey, scratch); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error adding static metadata to input container: %v", err)
+	}
+
+	return nil
+}
+
+// createOutputContainer creates the empty output container, staging its
+// files under scratch. The build is stopped if ctx is done first, and a
+// partly written image is removed on failure.
+func (cfg Container) createOutputContainer(ctx context.Context, scratch string) error {
+	if cfg.Size == autoPartitionSize || cfg.Size == 0 {
+		return fmt.Errorf("output container %s needs an explicit Size", cfg.Name)
+	}
+
+	outputPartition, err := cfg.createExt3Partition(ctx, "", scratch)
+	if err != nil {
+		return fmt.Errorf("error creating output filesystem: %v", err)
+	}
+
+	outputSifDesc, err := sif.NewDescriptorInput(sif.DataPartition, contextReader{ctx, outputPartition}, sif.OptObjectName(cfg.Name), sif.OptPartitionMetadata(sif.FsExt3, sif.PartData, "amd64"))
+	if err != nil {
+		return err
+	}
+
+	outputContainerUUID := uuid.NewV4()
+
+	path := cfg.Name + ".sif"
+	defer removeOnInterrupt(path)()
+
+	outputContainerImg, err := sif.CreateContainerAtPath(path, sif.OptCreateWithID(outputContainerUUID.String()), sif.OptCreateWithDescriptors(outputSifDesc))
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error creating output container image: %v", err)
+	}
+
+	if err := outputContainerImg.UnloadContainer(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+// createExt3Partition returns an ext3 partition of cfg.Size bytes whose root
+// holds the directory cfg.Name with a copy of inPath, if any, inside it. The
+// partition is built in process, falling back to mkfs.ext3 in a directory
+// under scratch when that fails for any reason other than the data not
+// fitting.
+func (cfg Container) createExt3Partition(ctx context.Context, inPath, scratch string) (io.Reader, error) {
+	img, err := buildExt3Image(int64(cfg.Size), cfg.Name, cfg.Name, inPath)
+	if err == nil {
+		return img.reader(), nil
+	} else if errors.Is(err, errPartitionTooSmall) {
+		return nil, err
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: %s: %v, falling back to mkfs.ext3\n", cfg.Name, err)
+
+	return cfg.createExt3PartitionExternal(ctx, inPath, scratch)
+}
+
+// createExt3PartitionExternal builds the partition with e2fsprogs, staging
+// the files and the raw image in a scratch directory of its own under
+// scratch. The external tools are killed if ctx is done first.
+func (cfg Container) createExt3PartitionExternal(ctx context.Context, inPath, scratch string) (io.Reader, error) {
+	dir, cleanup, err := newScratchDir(scratch, "ext3_"+cfg.Name+"_")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	stagingDir := filepath.Join(dir, "root")
+	stagingPath := filepath.Join(stagingDir, cfg.Name)
+	if err := os.MkdirAll(stagingPath, 0755); err != nil {
+		return nil, fmt.Errorf("error creating tmp directory: %v", err)
+	}
+
+	if inPath != "" {
+		if err := exec.CommandContext(
+			ctx,
+			"cp",
+			"-r",
+			inPath,
+			stagingPath,
+		).Run(); err != nil {
+			return nil, fmt.Errorf("error copying input files: %v", err)
+		}
+	}
+
+	imagePath := filepath.Join(dir, "partition.img")
+	containerFS, err := os.Create(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("error creating filesystem file: %v", err)
+	}
+	defer containerFS.Close()
+
+	if err := containerFS.Truncate(int64(cfg.Size)); err != nil {
+		return nil, fmt.Errorf("error truncating the filesystem file: %v", err)
+	}
+
+	if err := exec.CommandContext(
+		ctx,
+		"mkfs.ext3",
+		"-d",
+		stagingDir,
+		imagePath,
+	).Run(); err != nil {
+		return nil, fmt.Errorf("error creating filesystem: %v", err)
+	}
+
+	if err := exec.CommandContext(
+		ctx,
+		"tune2fs",
+		"-m",
+		"0",
+		imagePath,
+	).Run(); err != nil {
+		return nil, fmt.Errorf("error tuneing filesystem: %v", err)
+	}
+
+	partition, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(partition), nil
+}
+
+// contextReader is an io.Reader that fails with the error of ctx once ctx is
+// done, so that writing a partition into an image stops on cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func addStaticMetadata(ctx context.Context, name string, isInputContainer bool, buildKey, scratch string) error {
+	path := name + ".sif"
+
+	// input containers are identified by the files of their data
+	// partition, application containers by all of their objects
+	var digest, imageDigest *ContentDigest
+	var err error
+	if isInputContainer {
+		if digest, err = getContentDigest(ctx, path, scratch); err != nil {
+			return fmt.Errorf("error computing content digest: %v", err)
+		}
+	} else if imageDigest, err = getImageDigest(path); err != nil {
+		return fmt.Errorf("error computing image digest: %v", err)
+	}
+
+	containerImg, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDWR))
+	if err != nil {
+		return err
+	}
+
+	containerUuid, err := uuid.FromString(containerImg.ID())
+	if err != nil {
+		return err
+	}
+
+	metadata := Metadata{
+		UUID:             containerUuid,
+		Name:             name,
+		CreationTime:     containerImg.CreatedAt(),
+		ExecutionCommand: "no operation",
+		BuildKey:         buildKey,
+		RecordTrail:      nil,
+		ContentDigest:    digest,
+		ImageDigest:      imageDigest,
+	}
+
+	if isInputContainer {
+		metadata.RecordTrail = &RecordTrail{
+			OutputContainer: &ContainerRef{
+				Name: name,
+				UUID: containerUuid,
+			},
+			InputContainers:      nil,
+			ApplicationContainer: nil,
+		}
+	}
+
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	applicationSifMetadata, err := sif.NewDescriptorInput(sif.DataGenericJSON, bytes.NewReader(metadataJSON), sif.OptObjectName("metadata"))
+	if err != nil {
+		return err
+	}
+
+	if err := containerImg.AddObject(applicationSifMetadata); err != nil {
+		return err
+	}
+
+	if err := containerImg.UnloadContainer(); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBuildTasksReportUpstreamInputs(t *testing.T) {
+	stage := Workflow{
+		WorkflowName:         "analyse",
+		ApplicationContainer: Container{Name: "app"},
+		InputContainer:       []Container{{Name: "prepared", Stage: "prepare"}},
+		OutputContainer:      Container{Name: "results", Size: 4 << 20},
+	}
+	seen := map[string]bool{"app": true, "results": true}
+
+	tasks := stage.buildTasks(context.Background(), seen, workflowOptions{})
+	if len(tasks) != 1 || tasks[0].name != "prepared" {
+		t.Fatalf("tasks = %+v, want only that of the prepared input", tasks)
+	}
+
+	var lines []string
+	say := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	if err := tasks[0].build(say); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Skipping: input container 1: prepared is produced by stage prepare"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,5 @@
+// Package workflow creates and runs workflows of individual application,
+// input and output containers, and attaches the record trail of each run to
+// its output containers. It drives the apptainer binary, and provides the
+// commands of both the Apptainer plugin and the standalone tric binary.
+package workflow
@@ -0,0 +1,196 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resources are the CPUs and memory a run is expected to use. The local
+// executor only starts a run when its hints fit in what the host has left.
+type Resources struct {
+	CPUs   int  `json:",omitempty"`
+	Memory Size `json:",omitempty"`
+}
+
+const (
+	runPending   = "pending"
+	runRunning   = "running"
+	runSucceeded = "succeeded"
+	runFailed    = "failed"
+	runSkipped   = "skipped"
+	runCancelled = "cancelled"
+)
+
+// runStatus is the outcome of one stage run by the executor.
+type runStatus struct {
+	name     string
+	state    string
+	err      error
+	wallTime time.Duration
+}
+
+type runResult struct {
+	index    int
+	err      error
+	wallTime time.Duration
+}
+
+// executeStages runs the stages, in the order given, on at most opts.jobs
+// workers. A stage starts once every stage it depends on has succeeded and
+// its resource hints fit in the CPUs and memory not used by running stages;
+// a stage too large for the host runs alone. Unless opts.keepGoing is set,
+// the first failure cancels the running stages and every stage not yet
+// started. Stages depending on a failed stage are skipped. Cancelling ctx
+// likewise stops the run.
+func executeStages(ctx context.Context, stages []Workflow, opts workflowOptions) []runStatus {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := opts.jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	totalCPUs, totalMemory := runtime.NumCPU(), hostMemory()
+	freeCPUs, freeMemory := totalCPUs, totalMemory
+
+	statuses := make([]runStatus, len(stages))
+	index := make(map[string]int, len(stages))
+	for i, stage := range stages {
+		statuses[i] = runStatus{name: stage.WorkflowName, state: runPending}
+		index[stage.WorkflowName] = i
+	}
+
+	// stages come in dependency order, so a single pass in order sees the
+	// final state of every dependency that has finished
+	results := make(chan runResult)
+	running := 0
+	stopped := false
+
+	for {
+		for i, stage := range stages {
+			if statuses[i].state != runPending || stopped || ctx.Err() != nil {
+				continue
+			}
+
+			ready := true
+			for _, dependency := range stage.dependsOn {
+				switch statuses[index[dependency]].state {
+				case runSucceeded:
+				case runPending, runRunning:
+					ready = false
+				default:
+					statuses[i].state = runSkipped
+					statuses[i].err = fmt.Errorf("stage %s did not succeed", dependency)
+					ready = false
+				}
+			}
+			if !ready {
+				continue
+			}
+
+			cpus, memory := stage.resources()
+			if running > 0 && (running >= jobs || cpus > freeCPUs || (totalMemory > 0 && memory > freeMemory)) {
+				continue
+			}
+
+			freeCPUs -= cpus
+			freeMemory -= memory
+			running++
+			statuses[i].state = runRunning
+
+			fmt.Fprintf(os.Stdout, "Running: stage %s\n", stage.WorkflowName)
+			go func(i int, stage Workflow) {
+				start := time.Now()
+				err := stage.runStage(ctx, opts)
+				results <- runResult{index: i, err: err, wallTime: time.Since(start)}
+			}(i, stage)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		cpus, memory := stages[result.index].resources()
+		freeCPUs += cpus
+		freeMemory += memory
+
+		status := &statuses[result.index]
+		status.wallTime = result.wallTime
+		if result.err == nil {
+			status.state = runSucceeded
+			continue
+		}
+		status.state = runFailed
+		status.err = result.err
+		if !opts.keepGoing && !stopped {
+			stopped = true
+			cancel()
+		}
+	}
+
+	for i := range statuses {
+		if statuses[i].state == runPending {
+			statuses[i].state = runCancelled
+			statuses[i].err = fmt.Errorf("not started after an earlier failure")
+			if !stopped {
+				statuses[i].err = fmt.Errorf("not started: %v", ctx.Err())
+			}
+		}
+	}
+
+	return statuses
+}
+
+// resources returns the CPUs and bytes of memory the stage asks for.
+func (cfg Workflow) resources() (int, int64) {
+	if cfg.Resources == nil {
+		return 0, 0
+	}
+	return cfg.Resources.CPUs, int64(cfg.Resources.Memory)
+}
+
+// reportStatuses prints the outcome of every stage.
+func reportStatuses(statuses []runStatus) {
+	for _, status := range statuses {
+		line := fmt.Sprintf("Status: stage %s: %s", status.name, status.state)
+		if status.wallTime > 0 {
+			line += fmt.Sprintf(" in %s", status.wallTime.Round(time.Millisecond))
+		}
+		if status.err != nil {
+			line += fmt.Sprintf(": %v", status.err)
+		}
+		fmt.Fprintln(os.Stdout, line)
+	}
+}
+
+// hostMemory returns the total memory of the host in bytes, or 0 if it is
+// unknown.
+func hostMemory() int64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kib, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kib << 10
+		}
+	}
+
+	return 0
+}
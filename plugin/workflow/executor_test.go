@@ -0,0 +1,290 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// executorStage returns a stage named name whose application is called
+// with name as its only argument. It binds the output of each stage in
+// dependsOn, or the data input container when there are none.
+func executorStage(name string, dependsOn ...string) Stage {
+	stage := Stage{
+		Name:                 name,
+		ApplicationContainer: Container{Name: "app", InPath: "app.def"},
+		OutputContainer:      Container{Name: name + "_out", Size: 4 << 20},
+		Args:                 []string{name},
+	}
+	for _, dependency := range dependsOn {
+		stage.InputContainer = append(stage.InputContainer, Container{Stage: dependency})
+	}
+	if len(dependsOn) == 0 {
+		stage.InputContainer = []Container{{Name: "data", InPath: "data"}}
+	}
+	return stage
+}
+
+// createExecutorWorkflow creates a workflow of the stages in a new working
+// directory and returns them resolved in dependency order.
+func createExecutorWorkflow(t *testing.T, stages ...Stage) (Workflow, []Workflow) {
+	t.Helper()
+
+	chdirTemp(t)
+	writeTestFile(t, "app.def", testDefFile)
+	writeTestFile(t, "data/input.txt", "1,2,3\n")
+
+	wf := Workflow{WorkflowName: "test_executor", Stages: stages}
+	if err := Create(context.Background(), wf, WithRuntime(&FakeRuntime{})); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	resolved, err := wf.getStages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wf, resolved
+}
+
+// stageApplication is an application that calls the function of the
+// stage it runs, if any, and records when each stage starts and ends.
+type stageApplication struct {
+	stages map[string]func(ctx context.Context) error
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (a *stageApplication) record(event string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.events = append(a.events, event)
+}
+
+// index returns the position of event among the recorded events, or -1.
+func (a *stageApplication) index(event string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i, e := range a.events {
+		if e == event {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a *stageApplication) run(ctx context.Context, root string, inv Invocation, stdout, stderr io.Writer) error {
+	name := inv.Args[0]
+	a.record("start " + name)
+	defer a.record("end " + name)
+
+	if fn := a.stages[name]; fn != nil {
+		return fn(ctx)
+	}
+	return nil
+}
+
+func executorOptions(t *testing.T, app *stageApplication, options ...Option) workflowOptions {
+	t.Helper()
+
+	options = append(options, WithRuntime(&FakeRuntime{Application: app.run}))
+	opts, err := newOptions(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+// checkStatuses compares the state of every stage, and the start of its
+// error when one is given, with want.
+func checkStatuses(t *testing.T, statuses []runStatus, want map[string][2]string) {
+	t.Helper()
+
+	if len(statuses) != len(want) {
+		t.Errorf("%d statuses, want %d", len(statuses), len(want))
+	}
+	for _, status := range statuses {
+		w, ok := want[status.name]
+		if !ok {
+			t.Errorf("unexpected stage %s", status.name)
+			continue
+		}
+		if status.state != w[0] {
+			t.Errorf("stage %s %s (%v), want %s", status.name, status.state, status.err, w[0])
+		}
+		switch {
+		case w[1] == "" && status.err != nil:
+			t.Errorf("stage %s error %v, want none", status.name, status.err)
+		case w[1] != "" && (status.err == nil || !strings.HasPrefix(status.err.Error(), w[1])):
+			t.Errorf("stage %s error %v, want %q", status.name, status.err, w[1])
+		}
+	}
+}
+
+func TestExecutorWaitsForRunningDependency(t *testing.T) {
+	_, stages := createExecutorWorkflow(t,
+		executorStage("first"),
+		executorStage("second", "first"),
+		executorStage("other"),
+	)
+
+	// first only ends once other has started, so that second is looked at
+	// while first is still running
+	otherStarted := make(chan struct{})
+	app := &stageApplication{stages: map[string]func(context.Context) error{
+		"first": func(ctx context.Context) error {
+			select {
+			case <-otherStarted:
+				return nil
+			case <-time.After(10 * time.Second):
+				return errors.New("other did not run next to first")
+			}
+		},
+		"other": func(ctx context.Context) error {
+			close(otherStarted)
+			return nil
+		},
+	}}
+
+	statuses := executeStages(context.Background(), stages, executorOptions(t, app, WithJobs(2)))
+
+	checkStatuses(t, statuses, map[string][2]string{
+		"first":  {runSucceeded, ""},
+		"second": {runSucceeded, ""},
+		"other":  {runSucceeded, ""},
+	})
+	if end, start := app.index("end first"), app.index("start second"); start < end {
+		t.Errorf("second started before first ended: %q", app.events)
+	}
+}
+
+// failureStages are the stages of a run in which bad fails while slow is
+// running: slow runs until its context is cancelled, or for slowFor.
+func failureStages(t *testing.T, slowFor time.Duration) (Workflow, []Workflow, *stageApplication) {
+	t.Helper()
+
+	wf, stages := createExecutorWorkflow(t,
+		executorStage("bad"),
+		executorStage("slow"),
+		executorStage("later"),
+		executorStage("after_bad", "bad"),
+	)
+
+	app := &stageApplication{stages: map[string]func(context.Context) error{
+		"bad": func(ctx context.Context) error {
+			return FakeExitStatus(2)
+		},
+		"slow": func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(slowFor):
+				return nil
+			}
+		},
+	}}
+
+	return wf, stages, app
+}
+
+func TestExecutorCancelsOnFirstFailure(t *testing.T) {
+	_, stages, app := failureStages(t, 10*time.Second)
+
+	statuses := executeStages(context.Background(), stages, executorOptions(t, app, WithJobs(2)))
+
+	checkStatuses(t, statuses, map[string][2]string{
+		"bad":       {runFailed, "exit status 2"},
+		"slow":      {runFailed, "context canceled"},
+		"later":     {runCancelled, "not started after an earlier failure"},
+		"after_bad": {runCancelled, "not started after an earlier failure"},
+	})
+	if app.index("start later") >= 0 || app.index("start after_bad") >= 0 {
+		t.Errorf("stages started after the failure: %q", app.events)
+	}
+}
+
+func TestExecutorKeepGoing(t *testing.T) {
+	_, stages, app := failureStages(t, 50*time.Millisecond)
+
+	statuses := executeStages(context.Background(), stages, executorOptions(t, app, WithJobs(2), WithKeepGoing()))
+
+	checkStatuses(t, statuses, map[string][2]string{
+		"bad":       {runFailed, "exit status 2"},
+		"slow":      {runSucceeded, ""},
+		"later":     {runSucceeded, ""},
+		"after_bad": {runSkipped, "stage bad did not succeed"},
+	})
+}
+
+func TestExecutorJobsLimit(t *testing.T) {
+	for _, jobs := range []int{1, 2} {
+		t.Run(fmt.Sprint(jobs), func(t *testing.T) {
+			_, stages := createExecutorWorkflow(t,
+				executorStage("a"), executorStage("b"), executorStage("c"), executorStage("d"),
+			)
+
+			var mu sync.Mutex
+			active, maxActive := 0, 0
+			track := func(ctx context.Context) error {
+				mu.Lock()
+				active++
+				if active > maxActive {
+					maxActive = active
+				}
+				mu.Unlock()
+
+				time.Sleep(50 * time.Millisecond)
+
+				mu.Lock()
+				active--
+				mu.Unlock()
+				return nil
+			}
+			app := &stageApplication{stages: map[string]func(context.Context) error{
+				"a": track, "b": track, "c": track, "d": track,
+			}}
+
+			statuses := executeStages(context.Background(), stages, executorOptions(t, app, WithJobs(jobs)))
+
+			for _, status := range statuses {
+				if status.state != runSucceeded {
+					t.Errorf("stage %s %s: %v", status.name, status.state, status.err)
+				}
+			}
+			if maxActive != jobs {
+				t.Errorf("%d stages ran at once, want %d", maxActive, jobs)
+			}
+		})
+	}
+}
+
+func TestRunReportsStageErrors(t *testing.T) {
+	t.Run("keep going", func(t *testing.T) {
+		wf, _, app := failureStages(t, 50*time.Millisecond)
+
+		err := Run(context.Background(), wf, WithRuntime(&FakeRuntime{Application: app.run}), WithJobs(2), WithKeepGoing())
+		want := "2 of 4 stages did not succeed: error running stage bad: exit status 2"
+		if err == nil || err.Error() != want {
+			t.Errorf("run error = %v, want %q", err, want)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		wf, _ := createExecutorWorkflow(t, executorStage("only"))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := Run(ctx, wf, WithRuntime(&FakeRuntime{}))
+		want := "stage only cancelled: not started: context canceled"
+		if err == nil || err.Error() != want {
+			t.Errorf("run error = %v, want %q", err, want)
+		}
+	})
+}
@@ -0,0 +1,948 @@
+package workflow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// In-process writer for ext3 data partitions. The filesystem is laid out the
+// way mke2fs lays out a fresh revision 1 ext3 filesystem with 128 byte inodes,
+// sparse superblock backups, an internal journal and no reserved blocks, and
+// is populated from a host directory tree like `mkfs.ext3 -d`.
+
+const (
+	ext3SuperMagic      = 0xEF53
+	ext3JournalMagic    = 0xC03B3998
+	ext3InodeSize       = 128
+	ext3GroupDescSize   = 32
+	ext3RootIno         = 2
+	ext3JournalIno      = 8
+	ext3FirstIno        = 11
+	ext3NDirBlocks      = 12
+	ext3SmallFsBytes    = 512 << 20
+	ext3MinBlocks       = 2048
+	ext3FastSymlinkSize = 60
+
+	ext3FeatureCompatHasJournal   = 0x0004
+	ext3FeatureIncompatFiletype   = 0x0002
+	ext3FeatureRoCompatSparseSup  = 0x0001
+	ext3FeatureRoCompatLargeFile  = 0x0002
+	ext3JournalSuperblockV2       = 4
+	ext3JournalBackupBlocks       = 1
+	ext3ModeDir                   = 0x4000
+	ext3ModeRegular               = 0x8000
+	ext3ModeSymlink               = 0xA000
+	ext3FileTypeRegular           = 1
+	ext3FileTypeDir               = 2
+	ext3FileTypeSymlink           = 7
+	ext3DirEntryHeaderSize        = 8
+	ext3StateClean                = 1
+	ext3ErrorsContinue            = 1
+	ext3DynamicRev                = 1
+	ext3MaxMountCountDisabled     = 0xFFFF
+	ext3JournalSuperblockUsersOff = 0x100
+)
+
+type ext3Node struct {
+	name     string
+	mode     uint16
+	uid      uint32
+	gid      uint32
+	mtime    uint32
+	size     int64
+	hostPath string
+	target   string
+	children []*ext3Node
+	ino      uint32
+	parent   uint32
+}
+
+func (n *ext3Node) isDir() bool { return n.mode&0xF000 == ext3ModeDir }
+
+func (n *ext3Node) fileType() uint8 {
+	switch n.mode & 0xF000 {
+	case ext3ModeDir:
+		return ext3FileTypeDir
+	case ext3ModeSymlink:
+		return ext3FileTypeSymlink
+	default:
+		return ext3FileTypeRegular
+	}
+}
+
+// ext3Image is a laid out filesystem. Metadata blocks are held in memory and
+// file contents are read from the host when the image is streamed.
+type ext3Image struct {
+	blockSize   uint32
+	size        int64
+	blocksCount uint32
+	meta        map[uint32][]byte
+	runs        []ext3FileRun
+}
+
+// ext3FileRun maps count consecutive filesystem blocks starting at block to
+// the host file at path, starting at offset.
+type ext3FileRun struct {
+	block    uint32
+	count    uint32
+	path     string
+	offset   int64
+	fileSize int64
+}
+
+type ext3Builder struct {
+	blockSize       uint32
+	blocksCount     uint32
+	firstDataBlock  uint32
+	groups          uint32
+	gdtBlocks       uint32
+	inodesPerGroup  uint32
+	inodeTableBlks  uint32
+	journalBlocks   uint32
+	blockBitmaps    [][]byte
+	inodeBitmaps    [][]byte
+	usedDirs        []uint16
+	cursor          uint32
+	now             uint32
+	uuid            uuid.UUID
+	label           string
+	img             *ext3Image
+	journalBlockMap [15]uint32
+}
+
+// errPartitionTooSmall is returned when the data does not fit in the
+// requested partition size.
+var errPartitionTooSmall = errors.New("partition too small")
+
+// buildExt3Image lays out an ext3 filesystem of size bytes whose root holds
+// lost+found and the directory dirName, into which the host file or directory
+// tree at hostPath is copied when hostPath is not empty.
+func buildExt3Image(size int64, label, dirName, hostPath string) (*ext3Image, error) {
+	nodes, err := newExt3Tree(dirName, hostPath)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &ext3Builder{now: uint32(time.Now().Unix()), uuid: uuid.NewV4(), label: label}
+	if err := b.plan(size, nodes); err != nil {
+		return nil, err
+	}
+
+	if err := b.writeJournal(); err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if err := b.writeNode(node); err != nil {
+			return nil, err
+		}
+	}
+	b.writeGroupMetadata()
+
+	return b.img, nil
+}
+
+// ext3PartitionSize returns the smallest partition size, in whole MiB, whose
+// ext3 filesystem holds what buildExt3Image would write for dirName and
+// hostPath, after adding the headroom returned by extra.
+func ext3PartitionSize(dirName, hostPath string, extra func(int64) int64) (int64, error) {
+	nodes, err := newExt3Tree(dirName, hostPath)
+	if err != nil {
+		return 0, err
+	}
+
+	roundMiB := func(size int64) int64 {
+		return (size + 1<<20 - 1) &^ (1<<20 - 1)
+	}
+
+	size := int64(ext3MinBlocks * 1024)
+	fits := false
+	for size < 1<<44 {
+		b := &ext3Builder{}
+		err := b.plan(size, nodes)
+		if err != nil && !errors.Is(err, errPartitionTooSmall) {
+			return 0, err
+		}
+
+		if err == nil {
+			if fits {
+				return size, nil
+			}
+			// add the headroom once the data fits, then check again since
+			// a larger partition may use larger blocks
+			fits = true
+			size = roundMiB(size + extra(size))
+			continue
+		}
+
+		grow := size / 2
+		if b.img != nil {
+			if need, avail := b.requiredBlocks(nodes), b.freeBlocks(); need > avail {
+				grow = int64(need-avail) * int64(b.blockSize)
+			}
+		}
+		size = roundMiB(size + grow)
+	}
+
+	return 0, fmt.Errorf("data in %s is too large for an ext3 partition", hostPath)
+}
+
+// newExt3Tree returns the nodes of the filesystem tree, root directory
+// first, with inode numbers assigned.
+func newExt3Tree(dirName, hostPath string) ([]*ext3Node, error) {
+	now := uint32(time.Now().Unix())
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+
+	dir := &ext3Node{name: dirName, mode: ext3ModeDir | 0755, uid: uid, gid: gid, mtime: now}
+	if hostPath != "" {
+		child, err := walkExt3Tree(hostPath, uid, gid)
+		if err != nil {
+			return nil, err
+		}
+		dir.children = append(dir.children, child)
+	}
+	lostFound := &ext3Node{name: "lost+found", mode: ext3ModeDir | 0700, mtime: now}
+	root := &ext3Node{mode: ext3ModeDir | 0755, mtime: now, children: []*ext3Node{lostFound, dir}}
+
+	return assignExt3Inodes(root, lostFound), nil
+}
+
+// plan lays out a filesystem of size bytes and checks that nodes fit in it.
+func (b *ext3Builder) plan(size int64, nodes []*ext3Node) error {
+	if err := b.layout(size, uint32(len(nodes))+ext3FirstIno); err != nil {
+		return err
+	}
+
+	if need, avail := b.requiredBlocks(nodes), b.freeBlocks(); need > avail {
+		return fmt.Errorf("%w: data needs %d blocks of %d bytes, a %d byte ext3 partition has %d", errPartitionTooSmall, need, b.blockSize, size, avail)
+	}
+
+	return nil
+}
+
+// walkExt3Tree reads the host file or directory tree at path. Ownership is
+// set to uid and gid, as it would be after a plain `cp -r`.
+func walkExt3Tree(path string, uid, gid uint32) (*ext3Node, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &ext3Node{
+		name:  filepath.Base(path),
+		mode:  uint16(info.Mode().Perm()),
+		uid:   uid,
+		gid:   gid,
+		mtime: uint32(info.ModTime().Unix()),
+	}
+
+	switch {
+	case info.Mode().IsRegular():
+		node.mode |= ext3ModeRegular
+		node.size = info.Size()
+		node.hostPath = path
+	case info.IsDir():
+		node.mode |= ext3ModeDir
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			child, err := walkExt3Tree(filepath.Join(path, entry.Name()), uid, gid)
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, child)
+		}
+	case info.Mode()&os.ModeSymlink != 0:
+		node.mode |= ext3ModeSymlink
+		if node.target, err = os.Readlink(path); err != nil {
+			return nil, err
+		}
+		node.size = int64(len(node.target))
+	default:
+		return nil, fmt.Errorf("unsupported file type %v: %s", info.Mode().Type(), path)
+	}
+
+	if len(node.name) > 255 {
+		return nil, fmt.Errorf("file name too long: %s", path)
+	}
+
+	return node, nil
+}
+
+// assignExt3Inodes numbers the root directory and lost+found with their
+// reserved inodes and every other node from the first non-reserved inode on.
+func assignExt3Inodes(root, lostFound *ext3Node) []*ext3Node {
+	root.ino = ext3RootIno
+	root.parent = ext3RootIno
+	lostFound.ino = ext3FirstIno
+	nodes := []*ext3Node{root, lostFound}
+	next := uint32(ext3FirstIno + 1)
+
+	var walk func(dir *ext3Node)
+	walk = func(dir *ext3Node) {
+		for _, child := range dir.children {
+			if child.ino == 0 {
+				child.ino = next
+				next++
+				nodes = append(nodes, child)
+			}
+			child.parent = dir.ino
+		}
+		for _, child := range dir.children {
+			if child.isDir() {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+
+	return nodes
+}
+
+func ext3JournalSize(blocks uint32) uint32 {
+	switch {
+	case blocks < 32768:
+		return 1024
+	case blocks < 256*1024:
+		return 4096
+	case blocks < 512*1024:
+		return 8192
+	case blocks < 4096*1024:
+		return 16384
+	default:
+		return 32768
+	}
+}
+
+func ext3HasSuper(group uint32) bool {
+	if group <= 1 {
+		return true
+	}
+	for _, base := range []uint32{3, 5, 7} {
+		n := base
+		for n < group {
+			n *= base
+		}
+		if n == group {
+			return true
+		}
+	}
+	return false
+}
+
+func ceilDiv(n, d uint64) uint64 {
+	return (n + d - 1) / d
+}
+
+// layout computes the filesystem geometry for a partition of size bytes
+// holding at least inodes inodes.
+func (b *ext3Builder) layout(size int64, inodes uint32) error {
+	b.blockSize = 4096
+	inodeRatio := uint64(16384)
+	if size < ext3SmallFsBytes {
+		b.blockSize = 1024
+		inodeRatio = 4096
+	}
+	if b.blockSize == 1024 {
+		b.firstDataBlock = 1
+	}
+
+	blocks := uint64(size) / uint64(b.blockSize)
+	if blocks < ext3MinBlocks {
+		return fmt.Errorf("%w: %d bytes is too small for an ext3 filesystem", errPartitionTooSmall, size)
+	}
+	if blocks > 1<<32-1 {
+		return fmt.Errorf("partition of %d bytes is too large for an ext3 filesystem", size)
+	}
+	b.blocksCount = uint32(blocks)
+
+	blocksPerGroup := b.blockSize * 8
+	inodesPerBlock := b.blockSize / ext3InodeSize
+	for {
+		b.groups = uint32(ceilDiv(uint64(b.blocksCount-b.firstDataBlock), uint64(blocksPerGroup)))
+		b.gdtBlocks = uint32(ceilDiv(uint64(b.groups)*ext3GroupDescSize, uint64(b.blockSize)))
+
+		ipg := ceilDiv(uint64(b.blocksCount)*uint64(b.blockSize)/inodeRatio, uint64(b.groups))
+		if min := ceilDiv(uint64(inodes), uint64(b.groups)); ipg < min {
+			ipg = min
+		}
+		ipg = ceilDiv(ipg, uint64(inodesPerBlock)) * uint64(inodesPerBlock)
+		if ipg > uint64(blocksPerGroup) {
+			return fmt.Errorf("%w: too many files for a %d byte ext3 partition", errPartitionTooSmall, size)
+		}
+		b.inodesPerGroup = uint32(ipg)
+		b.inodeTableBlks = b.inodesPerGroup / inodesPerBlock
+
+		// drop a trailing group too small to hold its own metadata
+		last := b.groups - 1
+		if b.groups > 1 && b.groupBlocks(last) <= b.groupOverhead(last) {
+			b.blocksCount = b.firstDataBlock + last*blocksPerGroup
+			continue
+		}
+		break
+	}
+	if b.groupBlocks(0) <= b.groupOverhead(0) {
+		return fmt.Errorf("%w: %d bytes is too small for an ext3 filesystem", errPartitionTooSmall, size)
+	}
+
+	b.journalBlocks = ext3JournalSize(b.blocksCount)
+	b.img = &ext3Image{
+		blockSize:   b.blockSize,
+		size:        size,
+		blocksCount: b.blocksCount,
+		meta:        make(map[uint32][]byte),
+	}
+
+	b.blockBitmaps = make([][]byte, b.groups)
+	b.inodeBitmaps = make([][]byte, b.groups)
+	b.usedDirs = make([]uint16, b.groups)
+	for g := uint32(0); g < b.groups; g++ {
+		b.blockBitmaps[g] = make([]byte, b.blockSize)
+		b.inodeBitmaps[g] = make([]byte, b.blockSize)
+		start := b.groupStart(g)
+		for blk := uint32(0); blk < b.groupOverhead(g); blk++ {
+			b.markBlock(start + blk)
+		}
+		for bit := b.groupBlocks(g); bit < blocksPerGroup; bit++ {
+			b.blockBitmaps[g][bit/8] |= 1 << (bit % 8)
+		}
+		for bit := b.inodesPerGroup; bit < b.blockSize*8; bit++ {
+			b.inodeBitmaps[g][bit/8] |= 1 << (bit % 8)
+		}
+	}
+	for ino := uint32(1); ino < ext3FirstIno; ino++ {
+		b.markInode(ino)
+	}
+	b.cursor = b.firstDataBlock
+
+	return nil
+}
+
+func (b *ext3Builder) groupStart(group uint32) uint32 {
+	return b.firstDataBlock + group*b.blockSize*8
+}
+
+func (b *ext3Builder) groupBlocks(group uint32) uint32 {
+	if group == b.groups-1 {
+		return b.blocksCount - b.groupStart(group)
+	}
+	return b.blockSize * 8
+}
+
+func (b *ext3Builder) groupOverhead(group uint32) uint32 {
+	overhead := 2 + b.inodeTableBlks
+	if ext3HasSuper(group) {
+		overhead += 1 + b.gdtBlocks
+	}
+	return overhead
+}
+
+// groupLocations returns the block bitmap, inode bitmap and inode table
+// locations of group.
+func (b *ext3Builder) groupLocations(group uint32) (uint32, uint32, uint32) {
+	pos := b.groupStart(group)
+	if ext3HasSuper(group) {
+		pos += 1 + b.gdtBlocks
+	}
+	return pos, pos + 1, pos + 2
+}
+
+func (b *ext3Builder) freeBlocks() uint64 {
+	var free uint64
+	for g := uint32(0); g < b.groups; g++ {
+		free += uint64(b.groupBlocks(g) - b.groupOverhead(g))
+	}
+	return free
+}
+
+// ext3IndirectBlocks returns the number of indirect blocks needed to map n
+// data blocks with ptrs block pointers per block.
+func ext3IndirectBlocks(n, ptrs uint64) uint64 {
+	if n <= ext3NDirBlocks {
+		return 0
+	}
+	n -= ext3NDirBlocks
+	count := uint64(1)
+	if n <= ptrs {
+		return count
+	}
+	n -= ptrs
+	count++
+	if n <= ptrs*ptrs {
+		return count + ceilDiv(n, ptrs)
+	}
+	count += ptrs
+	n -= ptrs * ptrs
+	return count + 1 + ceilDiv(n, ptrs*ptrs) + ceilDiv(n, ptrs)
+}
+
+// dataBlocks returns the number of data blocks node occupies.
+func (b *ext3Builder) dataBlocks(node *ext3Node) uint64 {
+	switch node.mode & 0xF000 {
+	case ext3ModeDir:
+		return uint64(len(b.dirBlocks(node)))
+	case ext3ModeSymlink:
+		if len(node.target) < ext3FastSymlinkSize {
+			return 0
+		}
+		return 1
+	default:
+		return ceilDiv(uint64(node.size), uint64(b.blockSize))
+	}
+}
+
+func (b *ext3Builder) requiredBlocks(nodes []*ext3Node) uint64 {
+	ptrs := uint64(b.blockSize / 4)
+	need := uint64(b.journalBlocks) + ext3IndirectBlocks(uint64(b.journalBlocks), ptrs)
+	for _, node := range nodes {
+		n := b.dataBlocks(node)
+		need += n + ext3IndirectBlocks(n, ptrs)
+	}
+	return need
+}
+
+func (b *ext3Builder) markBlock(blk uint32) {
+	rel := blk - b.firstDataBlock
+	g, bit := rel/(b.blockSize*8), rel%(b.blockSize*8)
+	b.blockBitmaps[g][bit/8] |= 1 << (bit % 8)
+}
+
+func (b *ext3Builder) blockUsed(blk uint32) bool {
+	rel := blk - b.firstDataBlock
+	g, bit := rel/(b.blockSize*8), rel%(b.blockSize*8)
+	return b.blockBitmaps[g][bit/8]&(1<<(bit%8)) != 0
+}
+
+func (b *ext3Builder) markInode(ino uint32) {
+	g, bit := (ino-1)/b.inodesPerGroup, (ino-1)%b.inodesPerGroup
+	b.inodeBitmaps[g][bit/8] |= 1 << (bit % 8)
+}
+
+// alloc returns the next free block.
+func (b *ext3Builder) alloc() (uint32, error) {
+	for ; b.cursor < b.blocksCount; b.cursor++ {
+		if !b.blockUsed(b.cursor) {
+			blk := b.cursor
+			b.markBlock(blk)
+			b.cursor++
+			return blk, nil
+		}
+	}
+	return 0, fmt.Errorf("ext3 partition is full")
+}
+
+func (b *ext3Builder) metaBlock(blk uint32) []byte {
+	buf, ok := b.img.meta[blk]
+	if !ok {
+		buf = make([]byte, b.blockSize)
+		b.img.meta[blk] = buf
+	}
+	return buf
+}
+
+// allocData allocates n data blocks and the indirect blocks mapping them,
+// returning the data blocks and the inode block map.
+func (b *ext3Builder) allocData(n uint64) ([]uint32, [15]uint32, error) {
+	var blockMap [15]uint32
+
+	data := make([]uint32, 0, n)
+	for i := uint64(0); i < n; i++ {
+		blk, err := b.alloc()
+		if err != nil {
+			return nil, blockMap, err
+		}
+		data = append(data, blk)
+	}
+
+	rest := data
+	for i := 0; i < ext3NDirBlocks && len(rest) > 0; i++ {
+		blockMap[i] = rest[0]
+		rest = rest[1:]
+	}
+	for depth := 1; depth <= 3 && len(rest) > 0; depth++ {
+		blk, remaining, err := b.writeIndirect(depth, rest)
+		if err != nil {
+			return nil, blockMap, err
+		}
+		blockMap[ext3NDirBlocks-1+depth] = blk
+		rest = remaining
+	}
+	if len(rest) > 0 {
+		return nil, blockMap, fmt.Errorf("file too large for ext3")
+	}
+
+	return data, blockMap, nil
+}
+
+// writeIndirect allocates an indirect block of the given depth mapping as
+// many of data as it can and returns it with the blocks left unmapped.
+func (b *ext3Builder) writeIndirect(depth int, data []uint32) (uint32, []uint32, error) {
+	blk, err := b.alloc()
+	if err != nil {
+		return 0, nil, err
+	}
+	buf := b.metaBlock(blk)
+
+	for i := uint32(0); i < b.blockSize/4 && len(data) > 0; i++ {
+		ptr := data[0]
+		if depth == 1 {
+			data = data[1:]
+		} else {
+			ptr, data, err = b.writeIndirect(depth-1, data)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+		binary.LittleEndian.PutUint32(buf[i*4:], ptr)
+	}
+
+	return blk, data, nil
+}
+
+// dirBlocks returns the directory entry blocks of dir, including "." and
+// "..".
+func (b *ext3Builder) dirBlocks(dir *ext3Node) [][]byte {
+	type entry struct {
+		ino      uint32
+		name     string
+		fileType uint8
+	}
+	entries := []entry{{dir.ino, ".", ext3FileTypeDir}, {dir.parent, "..", ext3FileTypeDir}}
+	for _, child := range dir.children {
+		entries = append(entries, entry{child.ino, child.name, child.fileType()})
+	}
+
+	var blocks [][]byte
+	var buf []byte
+	pos, last := uint32(0), uint32(0)
+	for _, e := range entries {
+		recLen := (ext3DirEntryHeaderSize + uint32(len(e.name)) + 3) &^ 3
+		if buf == nil || pos+recLen > b.blockSize {
+			if buf != nil {
+				binary.LittleEndian.PutUint16(buf[last+4:], uint16(b.blockSize-last))
+			}
+			buf = make([]byte, b.blockSize)
+			blocks = append(blocks, buf)
+			pos = 0
+		}
+		binary.LittleEndian.PutUint32(buf[pos:], e.ino)
+		binary.LittleEndian.PutUint16(buf[pos+4:], uint16(recLen))
+		buf[pos+6] = uint8(len(e.name))
+		buf[pos+7] = e.fileType
+		copy(buf[pos+8:], e.name)
+		last = pos
+		pos += recLen
+	}
+	binary.LittleEndian.PutUint16(buf[last+4:], uint16(b.blockSize-last))
+
+	return blocks
+}
+
+func (b *ext3Builder) writeNode(node *ext3Node) error {
+	data, blockMap, err := b.allocData(b.dataBlocks(node))
+	if err != nil {
+		return err
+	}
+	indirect := ext3IndirectBlocks(uint64(len(data)), uint64(b.blockSize/4))
+	sectors := (uint64(len(data)) + indirect) * uint64(b.blockSize/512)
+
+	links := uint16(1)
+	size := node.size
+	switch node.mode & 0xF000 {
+	case ext3ModeDir:
+		links = 2
+		for _, child := range node.children {
+			if child.isDir() {
+				links++
+			}
+		}
+		for i, blk := range b.dirBlocks(node) {
+			copy(b.metaBlock(data[i]), blk)
+		}
+		size = int64(len(data)) * int64(b.blockSize)
+		b.usedDirs[(node.ino-1)/b.inodesPerGroup]++
+	case ext3ModeSymlink:
+		if len(data) == 0 {
+			var raw [60]byte
+			copy(raw[:], node.target)
+			for i := range blockMap {
+				blockMap[i] = binary.LittleEndian.Uint32(raw[i*4:])
+			}
+		} else {
+			copy(b.metaBlock(data[0]), node.target)
+		}
+	default:
+		b.addFileRuns(node, data)
+	}
+
+	b.writeInode(node.ino, node.mode, node.uid, node.gid, size, node.mtime, links, sectors, blockMap)
+	b.markInode(node.ino)
+
+	return nil
+}
+
+func (b *ext3Builder) addFileRuns(node *ext3Node, data []uint32) {
+	for i, blk := range data {
+		runs := b.img.runs
+		if n := len(runs); n > 0 {
+			run := &runs[n-1]
+			if run.path == node.hostPath && run.block+run.count == blk {
+				run.count++
+				continue
+			}
+		}
+		b.img.runs = append(b.img.runs, ext3FileRun{
+			block:    blk,
+			count:    1,
+			path:     node.hostPath,
+			offset:   int64(i) * int64(b.blockSize),
+			fileSize: node.size,
+		})
+	}
+}
+
+func (b *ext3Builder) writeInode(ino uint32, mode uint16, uid, gid uint32, size int64, mtime uint32, links uint16, sectors uint64, blockMap [15]uint32) {
+	g, index := (ino-1)/b.inodesPerGroup, (ino-1)%b.inodesPerGroup
+	_, _, table := b.groupLocations(g)
+	offset := index * ext3InodeSize
+	buf := b.metaBlock(table + offset/b.blockSize)[offset%b.blockSize:]
+
+	binary.LittleEndian.PutUint16(buf[0:], mode)
+	binary.LittleEndian.PutUint16(buf[2:], uint16(uid))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(size))
+	binary.LittleEndian.PutUint32(buf[8:], mtime)
+	binary.LittleEndian.PutUint32(buf[12:], b.now)
+	binary.LittleEndian.PutUint32(buf[16:], mtime)
+	binary.LittleEndian.PutUint16(buf[24:], uint16(gid))
+	binary.LittleEndian.PutUint16(buf[26:], links)
+	binary.LittleEndian.PutUint32(buf[28:], uint32(sectors))
+	for i, blk := range blockMap {
+		binary.LittleEndian.PutUint32(buf[40+i*4:], blk)
+	}
+	binary.LittleEndian.PutUint32(buf[108:], uint32(size>>32))
+	binary.LittleEndian.PutUint16(buf[116:], uint16(sectors>>32))
+	binary.LittleEndian.PutUint16(buf[120:], uint16(uid>>16))
+	binary.LittleEndian.PutUint16(buf[122:], uint16(gid>>16))
+}
+
+// writeJournal lays out the internal journal inode and writes an empty
+// journal superblock.
+func (b *ext3Builder) writeJournal() error {
+	data, blockMap, err := b.allocData(uint64(b.journalBlocks))
+	if err != nil {
+		return err
+	}
+
+	jsb := b.metaBlock(data[0])
+	binary.BigEndian.PutUint32(jsb[0:], ext3JournalMagic)
+	binary.BigEndian.PutUint32(jsb[4:], ext3JournalSuperblockV2)
+	binary.BigEndian.PutUint32(jsb[12:], b.blockSize)
+	binary.BigEndian.PutUint32(jsb[16:], b.journalBlocks)
+	binary.BigEndian.PutUint32(jsb[20:], 1)
+	binary.BigEndian.PutUint32(jsb[24:], 1)
+	copy(jsb[48:], b.uuid.Bytes())
+	binary.BigEndian.PutUint32(jsb[64:], 1)
+	copy(jsb[ext3JournalSuperblockUsersOff:], b.uuid.Bytes())
+
+	indirect := ext3IndirectBlocks(uint64(len(data)), uint64(b.blockSize/4))
+	sectors := (uint64(len(data)) + indirect) * uint64(b.blockSize/512)
+	size := int64(b.journalBlocks) * int64(b.blockSize)
+	b.writeInode(ext3JournalIno, ext3ModeRegular|0600, 0, 0, size, b.now, 1, sectors, blockMap)
+	b.journalBlockMap = blockMap
+
+	return nil
+}
+
+// writeGroupMetadata writes the bitmaps, group descriptors and superblocks
+// once every inode and block has been allocated.
+func (b *ext3Builder) writeGroupMetadata() {
+	gdt := make([]byte, b.gdtBlocks*b.blockSize)
+	var freeBlocks, freeInodes uint32
+	for g := uint32(0); g < b.groups; g++ {
+		blockBitmap, inodeBitmap, inodeTable := b.groupLocations(g)
+		copy(b.metaBlock(blockBitmap), b.blockBitmaps[g])
+		copy(b.metaBlock(inodeBitmap), b.inodeBitmaps[g])
+
+		groupFreeBlocks := b.groupBlocks(g) - countBits(b.blockBitmaps[g], b.groupBlocks(g))
+		groupFreeInodes := b.inodesPerGroup - countBits(b.inodeBitmaps[g], b.inodesPerGroup)
+		freeBlocks += groupFreeBlocks
+		freeInodes += groupFreeInodes
+
+		desc := gdt[g*ext3GroupDescSize:]
+		binary.LittleEndian.PutUint32(desc[0:], blockBitmap)
+		binary.LittleEndian.PutUint32(desc[4:], inodeBitmap)
+		binary.LittleEndian.PutUint32(desc[8:], inodeTable)
+		binary.LittleEndian.PutUint16(desc[12:], uint16(groupFreeBlocks))
+		binary.LittleEndian.PutUint16(desc[14:], uint16(groupFreeInodes))
+		binary.LittleEndian.PutUint16(desc[16:], b.usedDirs[g])
+	}
+
+	for g := uint32(0); g < b.groups; g++ {
+		if !ext3HasSuper(g) {
+			continue
+		}
+		start := b.groupStart(g)
+		sb := b.metaBlock(start)
+		if g == 0 && b.blockSize > 1024 {
+			sb = sb[1024:]
+		}
+		b.writeSuperblock(sb, g, freeBlocks, freeInodes)
+		for i := uint32(0); i < b.gdtBlocks; i++ {
+			copy(b.metaBlock(start+1+i), gdt[i*b.blockSize:(i+1)*b.blockSize])
+		}
+	}
+}
+
+func (b *ext3Builder) writeSuperblock(sb []byte, group, freeBlocks, freeInodes uint32) {
+	le := binary.LittleEndian
+	le.PutUint32(sb[0:], b.inodesPerGroup*b.groups)
+	le.PutUint32(sb[4:], b.blocksCount)
+	le.PutUint32(sb[12:], freeBlocks)
+	le.PutUint32(sb[16:], freeInodes)
+	le.PutUint32(sb[20:], b.firstDataBlock)
+	logSize := uint32(0)
+	for 1024<<logSize < b.blockSize {
+		logSize++
+	}
+	le.PutUint32(sb[24:], logSize)
+	le.PutUint32(sb[28:], logSize)
+	le.PutUint32(sb[32:], b.blockSize*8)
+	le.PutUint32(sb[36:], b.blockSize*8)
+	le.PutUint32(sb[40:], b.inodesPerGroup)
+	le.PutUint32(sb[48:], b.now)
+	le.PutUint16(sb[54:], ext3MaxMountCountDisabled)
+	le.PutUint16(sb[56:], ext3SuperMagic)
+	le.PutUint16(sb[58:], ext3StateClean)
+	le.PutUint16(sb[60:], ext3ErrorsContinue)
+	le.PutUint32(sb[64:], b.now)
+	le.PutUint32(sb[76:], ext3DynamicRev)
+	le.PutUint32(sb[84:], ext3FirstIno)
+	le.PutUint16(sb[88:], ext3InodeSize)
+	le.PutUint16(sb[90:], uint16(group))
+	le.PutUint32(sb[92:], ext3FeatureCompatHasJournal)
+	le.PutUint32(sb[96:], ext3FeatureIncompatFiletype)
+	le.PutUint32(sb[100:], ext3FeatureRoCompatSparseSup|ext3FeatureRoCompatLargeFile)
+	copy(sb[104:120], b.uuid.Bytes())
+	copy(sb[120:136], b.label)
+	le.PutUint32(sb[224:], ext3JournalIno)
+	sb[253] = ext3JournalBackupBlocks
+	le.PutUint32(sb[264:], b.now)
+	for i, blk := range b.journalBlockMap {
+		le.PutUint32(sb[268+i*4:], blk)
+	}
+	size := uint64(b.journalBlocks) * uint64(b.blockSize)
+	le.PutUint32(sb[268+15*4:], uint32(size>>32))
+	le.PutUint32(sb[268+16*4:], uint32(size))
+}
+
+func countBits(bitmap []byte, n uint32) uint32 {
+	var count uint32
+	for bit := uint32(0); bit < n; bit++ {
+		if bitmap[bit/8]&(1<<(bit%8)) != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// reader streams the image, reading file contents from the host.
+func (img *ext3Image) reader() io.Reader {
+	return &ext3ImageReader{img: img}
+}
+
+type ext3ImageReader struct {
+	img  *ext3Image
+	pos  int64
+	file *os.File
+}
+
+func (r *ext3ImageReader) Read(p []byte) (int, error) {
+	if r.pos >= r.img.size {
+		r.closeFile()
+		return 0, io.EOF
+	}
+
+	bs := int64(r.img.blockSize)
+	blk := uint32(r.pos / bs)
+	off := r.pos % bs
+	n := bs - off
+	if int64(len(p)) < n {
+		n = int64(len(p))
+	}
+	if r.img.size-r.pos < n {
+		n = r.img.size - r.pos
+	}
+	chunk := p[:n]
+
+	if data, ok := r.img.meta[blk]; ok {
+		copy(chunk, data[off:])
+	} else if run := r.img.findRun(blk); run != nil {
+		if err := r.readRun(run, blk, off, chunk); err != nil {
+			r.closeFile()
+			return 0, err
+		}
+	} else {
+		for i := range chunk {
+			chunk[i] = 0
+		}
+	}
+
+	r.pos += n
+	return int(n), nil
+}
+
+func (r *ext3ImageReader) readRun(run *ext3FileRun, blk uint32, off int64, chunk []byte) error {
+	if r.file == nil || r.file.Name() != run.path {
+		r.closeFile()
+		f, err := os.Open(run.path)
+		if err != nil {
+			return err
+		}
+		r.file = f
+	}
+
+	fileOff := run.offset + int64(blk-run.block)*int64(r.img.blockSize) + off
+	want := int64(len(chunk))
+	if run.fileSize-fileOff < want {
+		want = run.fileSize - fileOff
+	}
+	if want < 0 {
+		want = 0
+	}
+
+	n, err := r.file.ReadAt(chunk[:want], fileOff)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for i := n; i < len(chunk); i++ {
+		chunk[i] = 0
+	}
+
+	return nil
+}
+
+func (r *ext3ImageReader) closeFile() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+func (img *ext3Image) findRun(blk uint32) *ext3FileRun {
+	i := sort.Search(len(img.runs), func(i int) bool {
+		return img.runs[i].block+img.runs[i].count > blk
+	})
+	if i < len(img.runs) && img.runs[i].block <= blk {
+		return &img.runs[i]
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeExt3TestTree writes a directory tree with an empty file, a symlink
+// and a file large enough to need double indirect blocks, and returns its
+// path.
+func writeExt3TestTree(t *testing.T) string {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "data")
+	writeTestFile(t, filepath.Join(dir, "input.txt"), "1,2,3\n")
+	writeTestFile(t, filepath.Join(dir, "empty.txt"), "")
+
+	big := make([]byte, 3<<20+123)
+	rand.New(rand.NewSource(1)).Read(big)
+	writeTestFile(t, filepath.Join(dir, "nested", "deep", "big.bin"), string(big))
+
+	if err := os.Symlink("input.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// wantFileDigests returns the digests of the regular files under dir with
+// the paths they have in a partition that holds dir in the directory prefix.
+func wantFileDigests(t *testing.T, dir, prefix string) []FileDigest {
+	t.Helper()
+
+	files, err := hostFileDigests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		files[i].Path = path.Join(prefix, files[i].Path)
+	}
+
+	return files
+}
+
+func sortFileDigests(files []FileDigest) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+}
+
+// checkExt3Partition writes the partition read from r to a file, reads its
+// files back and compares them with want, then has e2fsck check it when
+// e2fsprogs is installed. It returns the file.
+func checkExt3Partition(t *testing.T, r io.Reader, want []FileDigest) *os.File {
+	t.Helper()
+
+	imagePath := filepath.Join(t.TempDir(), "partition.img")
+	image, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { image.Close() })
+	if _, err := io.Copy(image, r); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ext3FileDigests(image)
+	if err != nil {
+		t.Fatalf("reading partition: %v", err)
+	}
+	sortFileDigests(got)
+	sortFileDigests(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("partition files = %+v, want %+v", got, want)
+	}
+
+	e2fsck, err := exec.LookPath("e2fsck")
+	if err != nil {
+		t.Log("e2fsck not found, not checking the filesystem")
+		return image
+	}
+	if out, err := exec.Command(e2fsck, "-fn", imagePath).CombinedOutput(); err != nil {
+		t.Errorf("e2fsck: %v\n%s", err, out)
+	}
+
+	return image
+}
+
+func TestBuildExt3Image(t *testing.T) {
+	dir := writeExt3TestTree(t)
+
+	// one block group and several of 1 KiB blocks, and 4 KiB blocks
+	for _, size := range []int64{8 << 20, 64 << 20, ext3SmallFsBytes} {
+		img, err := buildExt3Image(size, "data", "data", dir)
+		if err != nil {
+			t.Fatalf("building %d byte image: %v", size, err)
+		}
+
+		image := checkExt3Partition(t, img.reader(), wantFileDigests(t, dir, "data/data"))
+		if info, err := image.Stat(); err != nil {
+			t.Fatal(err)
+		} else if info.Size() != size {
+			t.Errorf("image is %d bytes, want %d", info.Size(), size)
+		}
+
+		fsReader, err := openExt3(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a short symlink keeps its target in the block map of its inode
+		var target string
+		err = fsReader.walk(func(file ext3File) error {
+			if file.path != "data/data/link" {
+				return nil
+			}
+			if file.mode&ext3ModeMask != ext3ModeSymlink {
+				t.Errorf("link has mode %o, want a symlink", file.mode)
+			}
+			target = string(file.inode[40 : 40+file.size])
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target != "input.txt" {
+			t.Errorf("link target = %q, want input.txt", target)
+		}
+	}
+}
+
+func TestBuildEmptyExt3Image(t *testing.T) {
+	img, err := buildExt3Image(4<<20, "results", "results", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkExt3Partition(t, img.reader(), nil)
+}
+
+func TestReadExternalExt3Image(t *testing.T) {
+	for _, tool := range []string{"mkfs.ext3", "tune2fs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	dir := writeExt3TestTree(t)
+
+	cfg := Container{Name: "data", Size: 8 << 20}
+	partition, err := cfg.createExt3PartitionExternal(context.Background(), dir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkExt3Partition(t, partition, wantFileDigests(t, dir, "data/data"))
+}
+
+func TestExt3PartitionSize(t *testing.T) {
+	dir := writeExt3TestTree(t)
+	noHeadroom := func(int64) int64 { return 0 }
+
+	size, err := ext3PartitionSize("data", dir, noHeadroom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size%(1<<20) != 0 {
+		t.Errorf("size %d is not a whole number of MiB", size)
+	}
+
+	img, err := buildExt3Image(size, "data", "data", dir)
+	if err != nil {
+		t.Fatalf("data does not fit in the %d bytes computed for it: %v", size, err)
+	}
+	checkExt3Partition(t, img.reader(), wantFileDigests(t, dir, "data/data"))
+
+	if _, err := buildExt3Image(size-1<<20, "data", "data", dir); !errors.Is(err, errPartitionTooSmall) {
+		t.Errorf("building a %d byte image: error %v, want %v", size-1<<20, err, errPartitionTooSmall)
+	}
+
+	withHeadroom, err := ext3PartitionSize("data", dir, func(int64) int64 { return 4 << 20 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withHeadroom < size+4<<20 {
+		t.Errorf("size with 4 MiB headroom = %d, want at least %d", withHeadroom, size+4<<20)
+	}
+}
@@ -0,0 +1,295 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+)
+
+// Read-only access to the files of an ext2, ext3 or ext4 data partition,
+// enough to walk its directories and read regular files. Block mapped and
+// extent mapped files are supported; inline data and encryption are not.
+
+const (
+	ext4FeatureIncompat64Bit  = 0x0080
+	ext4FeatureIncompatInline = 0x8000
+	ext4InodeFlagExtents      = 0x80000
+	ext4ExtentMagic           = 0xF30A
+	ext3ModeMask              = 0xF000
+)
+
+type ext3Reader struct {
+	r              io.ReaderAt
+	blockSize      uint32
+	inodesPerGroup uint32
+	inodeSize      uint32
+	descSize       uint32
+	gdtOffset      int64
+	filetype       bool
+}
+
+// ext3File is a file found while walking an ext3 partition.
+type ext3File struct {
+	path  string
+	ino   uint32
+	mode  uint16
+	size  int64
+	inode []byte
+}
+
+func openExt3(r io.ReaderAt) (*ext3Reader, error) {
+	sb := make([]byte, 1024)
+	if _, err := r.ReadAt(sb, 1024); err != nil {
+		return nil, fmt.Errorf("error reading superblock: %v", err)
+	}
+
+	le := binary.LittleEndian
+	if le.Uint16(sb[56:]) != ext3SuperMagic {
+		return nil, fmt.Errorf("not an ext2/3/4 filesystem")
+	}
+
+	incompat := le.Uint32(sb[96:])
+	if incompat&ext4FeatureIncompatInline != 0 {
+		return nil, fmt.Errorf("ext4 inline data is not supported")
+	}
+
+	e := &ext3Reader{
+		r:              r,
+		blockSize:      1024 << le.Uint32(sb[24:]),
+		inodesPerGroup: le.Uint32(sb[40:]),
+		inodeSize:      ext3InodeSize,
+		descSize:       ext3GroupDescSize,
+		filetype:       incompat&ext3FeatureIncompatFiletype != 0,
+	}
+	if le.Uint32(sb[76:]) >= ext3DynamicRev {
+		e.inodeSize = uint32(le.Uint16(sb[88:]))
+	}
+	if incompat&ext4FeatureIncompat64Bit != 0 {
+		if size := uint32(le.Uint16(sb[254:])); size > ext3GroupDescSize {
+			e.descSize = size
+		}
+	}
+	e.gdtOffset = int64(le.Uint32(sb[20:])+1) * int64(e.blockSize)
+
+	return e, nil
+}
+
+func (e *ext3Reader) readInode(ino uint32) ([]byte, error) {
+	le := binary.LittleEndian
+	group, index := (ino-1)/e.inodesPerGroup, (ino-1)%e.inodesPerGroup
+
+	desc := make([]byte, e.descSize)
+	if _, err := e.r.ReadAt(desc, e.gdtOffset+int64(group)*int64(e.descSize)); err != nil {
+		return nil, err
+	}
+	table := uint64(le.Uint32(desc[8:]))
+	if e.descSize > ext3GroupDescSize {
+		table |= uint64(le.Uint32(desc[40:])) << 32
+	}
+
+	inode := make([]byte, e.inodeSize)
+	offset := int64(table)*int64(e.blockSize) + int64(index)*int64(e.inodeSize)
+	if _, err := e.r.ReadAt(inode, offset); err != nil {
+		return nil, err
+	}
+
+	return inode, nil
+}
+
+func inodeSize(inode []byte) int64 {
+	le := binary.LittleEndian
+	return int64(le.Uint32(inode[4:])) | int64(le.Uint32(inode[108:]))<<32
+}
+
+// blockMap returns the physical block of every logical block of the inode,
+// with 0 for holes.
+func (e *ext3Reader) blockMap(inode []byte) ([]uint64, error) {
+	le := binary.LittleEndian
+	n := uint64(ceilDiv(uint64(inodeSize(inode)), uint64(e.blockSize)))
+	blocks := make([]uint64, n)
+
+	if le.Uint32(inode[32:])&ext4InodeFlagExtents != 0 {
+		if err := e.extentMap(inode[40:100], blocks); err != nil {
+			return nil, err
+		}
+		return blocks, nil
+	}
+
+	logical := uint64(0)
+	for i := 0; i < ext3NDirBlocks && logical < n; i++ {
+		blocks[logical] = uint64(le.Uint32(inode[40+i*4:]))
+		logical++
+	}
+	for depth := 1; depth <= 3 && logical < n; depth++ {
+		ptr := le.Uint32(inode[40+(ext3NDirBlocks-1+depth)*4:])
+		var err error
+		if logical, err = e.indirectMap(ptr, depth, blocks, logical); err != nil {
+			return nil, err
+		}
+	}
+
+	return blocks, nil
+}
+
+// indirectMap fills blocks from logical on with the blocks mapped by the
+// indirect block ptr of the given depth, returning the next logical block.
+func (e *ext3Reader) indirectMap(ptr uint32, depth int, blocks []uint64, logical uint64) (uint64, error) {
+	ptrs := uint64(e.blockSize / 4)
+	span := uint64(1)
+	for i := 1; i < depth; i++ {
+		span *= ptrs
+	}
+
+	if ptr == 0 {
+		return logical + span*ptrs, nil
+	}
+
+	buf := make([]byte, e.blockSize)
+	if _, err := e.r.ReadAt(buf, int64(ptr)*int64(e.blockSize)); err != nil {
+		return 0, err
+	}
+
+	for i := uint64(0); i < ptrs && logical < uint64(len(blocks)); i++ {
+		child := binary.LittleEndian.Uint32(buf[i*4:])
+		if depth == 1 {
+			blocks[logical] = uint64(child)
+			logical++
+			continue
+		}
+		var err error
+		if logical, err = e.indirectMap(child, depth-1, blocks, logical); err != nil {
+			return 0, err
+		}
+	}
+
+	return logical, nil
+}
+
+// extentMap fills blocks from the extent tree node in node.
+func (e *ext3Reader) extentMap(node []byte, blocks []uint64) error {
+	le := binary.LittleEndian
+	if le.Uint16(node[0:]) != ext4ExtentMagic {
+		return fmt.Errorf("bad extent header")
+	}
+	entries, depth := int(le.Uint16(node[2:])), le.Uint16(node[6:])
+
+	for i := 0; i < entries; i++ {
+		entry := node[12+i*12:]
+		if depth == 0 {
+			logical := uint64(le.Uint32(entry[0:]))
+			length := uint64(le.Uint16(entry[4:]))
+			if length > 32768 {
+				// uninitialized extent, reads as zeros
+				continue
+			}
+			start := uint64(le.Uint16(entry[6:]))<<32 | uint64(le.Uint32(entry[8:]))
+			for j := uint64(0); j < length && logical+j < uint64(len(blocks)); j++ {
+				blocks[logical+j] = start + j
+			}
+			continue
+		}
+
+		leaf := uint64(le.Uint16(entry[8:]))<<32 | uint64(le.Uint32(entry[4:]))
+		buf := make([]byte, e.blockSize)
+		if _, err := e.r.ReadAt(buf, int64(leaf)*int64(e.blockSize)); err != nil {
+			return err
+		}
+		if err := e.extentMap(buf, blocks); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyFile writes the contents of the regular file with the given inode to w.
+func (e *ext3Reader) copyFile(w io.Writer, inode []byte) error {
+	blocks, err := e.blockMap(inode)
+	if err != nil {
+		return err
+	}
+
+	remaining := inodeSize(inode)
+	buf := make([]byte, e.blockSize)
+	for _, blk := range blocks {
+		n := int64(e.blockSize)
+		if remaining < n {
+			n = remaining
+		}
+		if blk == 0 {
+			for i := range buf {
+				buf[i] = 0
+			}
+		} else if _, err := e.r.ReadAt(buf[:n], int64(blk)*int64(e.blockSize)); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		remaining -= n
+	}
+
+	return nil
+}
+
+// walk calls fn for every file below the root directory, in directory
+// order, skipping lost+found.
+func (e *ext3Reader) walk(fn func(ext3File) error) error {
+	return e.walkDir(ext3RootIno, "", fn)
+}
+
+func (e *ext3Reader) walkDir(ino uint32, dir string, fn func(ext3File) error) error {
+	inode, err := e.readInode(ino)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := e.copyFile(&buf, inode); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+
+	le := binary.LittleEndian
+	for pos := 0; pos+ext3DirEntryHeaderSize <= len(data); {
+		entryIno := le.Uint32(data[pos:])
+		recLen := int(le.Uint16(data[pos+4:]))
+		nameLen := int(data[pos+6])
+		if !e.filetype {
+			nameLen = int(le.Uint16(data[pos+6:]))
+		}
+		if recLen < ext3DirEntryHeaderSize || pos+recLen > len(data) || ext3DirEntryHeaderSize+nameLen > recLen {
+			return fmt.Errorf("corrupt directory entry in %s", "/"+dir)
+		}
+		name := string(data[pos+ext3DirEntryHeaderSize : pos+ext3DirEntryHeaderSize+nameLen])
+		pos += recLen
+
+		if entryIno == 0 || name == "." || name == ".." || (dir == "" && name == "lost+found") {
+			continue
+		}
+
+		child, err := e.readInode(entryIno)
+		if err != nil {
+			return err
+		}
+		file := ext3File{
+			path:  path.Join(dir, name),
+			ino:   entryIno,
+			mode:  le.Uint16(child[0:]),
+			size:  inodeSize(child),
+			inode: child,
+		}
+		if err := fn(file); err != nil {
+			return err
+		}
+		if file.mode&ext3ModeMask == ext3ModeDir {
+			if err := e.walkDir(entryIno, file.path, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
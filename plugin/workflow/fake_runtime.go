@@ -0,0 +1,204 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/apptainer/sif/v2/pkg/sif"
+	uuid "github.com/satori/go.uuid"
+)
+
+// FakeRuntime is a Runtime that simulates builds and runs without a
+// container runtime and records every call, for testing programs that
+// create and run workflows. Build writes an image holding only the def
+// file. Run extracts the data of each bound container into a temporary
+// directory standing for the root of the container and calls Application
+// in its place; what the application writes is not copied back into the
+// output containers.
+type FakeRuntime struct {
+	// Application simulates the application of each run. root is the
+	// directory standing for the root of the container, so that each bind
+	// mount of inv is found at root+Destination. A nil Application
+	// succeeds without output.
+	Application func(ctx context.Context, root string, inv Invocation, stdout, stderr io.Writer) error
+	// BuildErrors holds the error returned by the build of each image.
+	BuildErrors map[string]error
+	// TmpDir is the directory under which runs are staged, the system
+	// temporary directory if empty.
+	TmpDir string
+
+	mu    sync.Mutex
+	calls []FakeCall
+}
+
+// FakeCall is a call made to a FakeRuntime.
+type FakeCall struct {
+	// Method is Build, Run or Inspect.
+	Method string
+	// Image is the container image built, run or inspected.
+	Image string
+	// DefPath is the def file of a build.
+	DefPath string
+	// Invocation is the invocation of a run.
+	Invocation *Invocation
+}
+
+// fakeExitError is the error of an application that exited with a non-zero
+// status.
+type fakeExitError int
+
+func (e fakeExitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+func (e fakeExitError) ExitCode() int { return int(e) }
+
+// FakeExitStatus returns the error an Application returns to exit with
+// status.
+func FakeExitStatus(status int) error {
+	return fakeExitError(status)
+}
+
+// Calls returns the calls made so far, in order.
+func (f *FakeRuntime) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeCall(nil), f.calls...)
+}
+
+func (f *FakeRuntime) record(call FakeCall) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, call)
+}
+
+func (f *FakeRuntime) Build(ctx context.Context, image, defPath, tmpDir string) error {
+	f.record(FakeCall{Method: "Build", Image: image, DefPath: defPath})
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := f.BuildErrors[image]; err != nil {
+		return err
+	}
+
+	defFile, err := os.ReadFile(defPath)
+	if err != nil {
+		return err
+	}
+
+	input, err := sif.NewDescriptorInput(sif.DataDeffile, bytes.NewReader(defFile))
+	if err != nil {
+		return err
+	}
+
+	img, err := sif.CreateContainerAtPath(image, sif.OptCreateWithID(uuid.NewV4().String()), sif.OptCreateWithDescriptors(input))
+	if err != nil {
+		return err
+	}
+
+	return img.UnloadContainer()
+}
+
+func (f *FakeRuntime) Run(ctx context.Context, inv Invocation, stdout, stderr io.Writer) error {
+	f.record(FakeCall{Method: "Run", Image: inv.Image, Invocation: &inv})
+
+	root, cleanup, err := newScratchDir(f.TmpDir, "fake_run_")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	for _, bind := range inv.Binds {
+		dest := filepath.Join(root, bind.Destination)
+		if err := extractPartition(bind.Source, strings.TrimPrefix(bind.ImageSrc, "/"), dest); err != nil {
+			return fmt.Errorf("error staging %s at %s: %v", bind.Source, bind.Destination, err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f.Application == nil {
+		return nil
+	}
+
+	return f.Application(ctx, root, inv, stdout, stderr)
+}
+
+func (f *FakeRuntime) Inspect(image string) (string, error) {
+	f.record(FakeCall{Method: "Inspect", Image: image})
+
+	return readDefFile(image)
+}
+
+func (f *FakeRuntime) Version() string {
+	return "fake"
+}
+
+// extractPartition copies the directory dir of the ext3 data partition of
+// the container image at image to dest.
+func extractPartition(image, dir, dest string) error {
+	img, err := sif.LoadContainerFromPath(image, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return err
+	}
+	defer img.UnloadContainer()
+
+	descriptor, err := img.GetDescriptor(sif.WithPartitionType(sif.PartData))
+	if err != nil {
+		return fmt.Errorf("could not retrieve data partition: %v", err)
+	}
+	if fsType, _, _, err := descriptor.PartitionMetadata(); err != nil {
+		return err
+	} else if fsType != sif.FsExt3 {
+		return fmt.Errorf("cannot extract %v partition", fsType)
+	}
+
+	imageFile, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer imageFile.Close()
+
+	fsReader, err := openExt3(io.NewSectionReader(imageFile, descriptor.Offset(), descriptor.Size()))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	return fsReader.walk(func(file ext3File) error {
+		rel := strings.TrimPrefix(file.path, dir+"/")
+		if rel == file.path {
+			return nil
+		}
+		target := filepath.Join(dest, filepath.FromSlash(path.Clean(rel)))
+
+		switch file.mode & ext3ModeMask {
+		case ext3ModeDir:
+			return os.MkdirAll(target, 0755)
+		case ext3ModeRegular:
+			out, err := os.Create(target)
+			if err != nil {
+				return err
+			}
+			if err := fsReader.copyFile(out, file.inode); err != nil {
+				out.Close()
+				return err
+			}
+			return out.Close()
+		}
+
+		return nil
+	})
+}
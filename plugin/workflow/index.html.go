@@ -1,4 +1,4 @@
-package main
+package workflow
 
 const indexHTML = `
 <!doctype html>
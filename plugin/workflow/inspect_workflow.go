@@ -0,0 +1,61 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/apptainer/sif/v2/pkg/sif"
+)
+
+// inspectContainer prints the metadata of the container image at path or,
+// when log is stdout or stderr, the output of its run captured in it.
+func inspectContainer(path, log string) error {
+	if log == "" {
+		metadata, err := readContainerMetadata(path)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(metadata, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+
+		return nil
+	}
+
+	if log != "stdout" && log != "stderr" {
+		return fmt.Errorf("unknown log %q, must be stdout or stderr", log)
+	}
+	object := log + ".log"
+
+	img, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return err
+	}
+	defer img.UnloadContainer()
+
+	descriptors, err := img.GetDescriptors(sif.WithDataType(sif.DataGeneric))
+	if err != nil {
+		return fmt.Errorf("could not retrieve container descriptions: %v", err)
+	}
+
+	// the most recent run's output is the last one added
+	var data []byte
+	for _, descriptor := range descriptors {
+		if descriptor.Name() != object {
+			continue
+		}
+		if data, err = descriptor.GetData(); err != nil {
+			return err
+		}
+	}
+	if data == nil {
+		return fmt.Errorf("%s has no %s", path, object)
+	}
+
+	_, err = os.Stdout.Write(data)
+	return err
+}
@@ -0,0 +1,132 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Invocation is the apptainer command that runs a workflow application,
+// kept as separate arguments so that names and paths are never split or
+// reinterpreted by a shell. It is stored verbatim in the output metadata so
+// that the run can be replayed exactly.
+type Invocation struct {
+	Binary  string
+	Command string
+	Flags   []string          `json:",omitempty"`
+	Binds   []BindMount       `json:",omitempty"`
+	Env     map[string]string `json:",omitempty"`
+	Image   string
+	Args    []string `json:",omitempty"`
+}
+
+// BindMount binds the data partition of the container image Source at
+// Destination.
+type BindMount struct {
+	Source      string
+	Destination string
+	ImageSrc    string
+	ReadOnly    bool `json:",omitempty"`
+}
+
+// newInvocation returns the invocation that runs the application container
+// of the workflow with its input and output containers bound and its Args and
+// Env passed through.
+func (cfg Workflow) newInvocation() (Invocation, error) {
+	inv := Invocation{
+		Binary:  "apptainer",
+		Command: "run",
+		Flags:   cfg.Flags,
+		Image:   cfg.ApplicationContainer.Name + ".sif",
+		Args:    cfg.Args,
+		Env:     cfg.Env,
+	}
+
+	for key := range cfg.Env {
+		if key == "" || strings.ContainsAny(key, "= ") {
+			return inv, fmt.Errorf("invalid environment variable name: %q", key)
+		}
+	}
+
+	// Input containers, including the outputs of upstream stages, are bound
+	// read-only whatever their format: concurrent runs may bind the same
+	// image, and their recorded digests must keep matching.
+	for _, inputContainer := range cfg.InputContainer {
+		inv.Binds = append(inv.Binds, BindMount{
+			Source:      inputContainer.Name + ".sif",
+			Destination: inputContainer.mountPoint(),
+			ImageSrc:    "/" + inputContainer.Name,
+			ReadOnly:    true,
+		})
+	}
+
+	for _, outputContainer := range cfg.outputContainers() {
+		inv.Binds = append(inv.Binds, BindMount{
+			Source:      outputContainer.Name + ".sif",
+			Destination: outputContainer.mountPoint(),
+			ImageSrc:    "/" + outputContainer.Name,
+		})
+	}
+
+	return inv, nil
+}
+
+// mountPoint returns the path the container is bound at.
+func (cfg Container) mountPoint() string {
+	if cfg.mount != "" {
+		return "/" + cfg.mount
+	}
+	return "/" + cfg.Name
+}
+
+func (b BindMount) String() string {
+	spec := b.Source + ":" + b.Destination + ":image-src=" + b.ImageSrc
+	if b.ReadOnly {
+		spec += ",ro"
+	}
+	return spec
+}
+
+// argv returns the command line of the invocation.
+func (inv Invocation) argv() []string {
+	argv := []string{inv.Binary, inv.Command}
+	argv = append(argv, inv.Flags...)
+	for _, bind := range inv.Binds {
+		argv = append(argv, "-B", bind.String())
+	}
+	argv = append(argv, inv.Image)
+	return append(argv, inv.Args...)
+}
+
+// containerEnv returns Env as variables that apptainer passes into the
+// container through its APPTAINERENV_ prefix, sorted by name.
+func (inv Invocation) containerEnv() []string {
+	keys := make([]string, 0, len(inv.Env))
+	for key := range inv.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, key := range keys {
+		env = append(env, "APPTAINERENV_"+key+"="+inv.Env[key])
+	}
+	return env
+}
+
+// environ returns the environment of the invocation: the current one plus
+// the container variables.
+func (inv Invocation) environ() []string {
+	return append(os.Environ(), inv.containerEnv()...)
+}
+
+// command returns the invocation ready to be run, killed if ctx is done
+// before it exits.
+func (inv Invocation) command(ctx context.Context) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, inv.Binary, inv.argv()[1:]...)
+	cmd.Env = inv.environ()
+	return cmd
+}
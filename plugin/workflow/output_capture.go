@@ -0,0 +1,92 @@
+package workflow
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/apptainer/sif/v2/pkg/sif"
+)
+
+// outputCaptureLimit caps the bytes of each output stream of a run that are
+// stored in the output containers.
+const outputCaptureLimit = 1 << 20
+
+// capturedOutput keeps the first and last bytes written to one output stream
+// of a run, up to limit bytes in total.
+type capturedOutput struct {
+	object string
+	limit  int
+	head   []byte
+	tail   []byte
+	total  int64
+}
+
+// OutputLog refers to a captured output stream stored in a container.
+type OutputLog struct {
+	Object    string
+	Size      int64
+	Truncated bool
+}
+
+func newCapturedOutput(object string, limit int) *capturedOutput {
+	return &capturedOutput{object: object, limit: limit}
+}
+
+func (c *capturedOutput) Write(p []byte) (int, error) {
+	c.total += int64(len(p))
+
+	rest := p
+	if room := c.limit/2 - len(c.head); room > 0 {
+		if room > len(rest) {
+			room = len(rest)
+		}
+		c.head = append(c.head, rest[:room]...)
+		rest = rest[room:]
+	}
+
+	keep := c.limit - c.limit/2
+	if len(rest) >= keep {
+		c.tail = append(c.tail[:0], rest[len(rest)-keep:]...)
+	} else if excess := len(c.tail) + len(rest) - keep; excess > 0 {
+		c.tail = append(c.tail[:copy(c.tail, c.tail[excess:])], rest...)
+	} else {
+		c.tail = append(c.tail, rest...)
+	}
+
+	return len(p), nil
+}
+
+func (c *capturedOutput) truncated() bool {
+	return c.total > int64(len(c.head)+len(c.tail))
+}
+
+// bytes returns the captured output, with a marker in place of any bytes
+// dropped between the head and the tail.
+func (c *capturedOutput) bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(c.head)
+	if c.truncated() {
+		fmt.Fprintf(&buf, "\n[... %d bytes truncated ...]\n", c.total-int64(len(c.head)+len(c.tail)))
+	}
+	buf.Write(c.tail)
+	return buf.Bytes()
+}
+
+// addTo stores the captured output in img as a generic data object and
+// returns a reference to it.
+func (c *capturedOutput) addTo(img *sif.FileImage) (*OutputLog, error) {
+	input, err := sif.NewDescriptorInput(sif.DataGeneric, bytes.NewReader(c.bytes()), sif.OptObjectName(c.object))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := img.AddObject(input); err != nil {
+		return nil, fmt.Errorf("error adding %s: %v", c.object, err)
+	}
+
+	return &OutputLog{
+		Object:    c.object,
+		Size:      c.total,
+		Truncated: c.truncated(),
+	}, nil
+}
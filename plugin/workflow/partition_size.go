@@ -0,0 +1,152 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is the size in bytes of a data partition. In a workflow
+// description it is a number of bytes, a string with a unit such as "2GiB"
+// or "500MB", or "auto" to size an input container from its InPath.
+type Size int64
+
+const autoPartitionSize Size = -1
+
+const defaultHeadroom = "10%"
+
+// sizeUnits are the units of a size, in upper case as they are matched
+// case-insensitively. The size pattern of schema/workflow-v1.schema.json
+// accepts the same units.
+var sizeUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KI":  1 << 10,
+	"KIB": 1 << 10,
+	"KB":  1e3,
+	"M":   1 << 20,
+	"MI":  1 << 20,
+	"MIB": 1 << 20,
+	"MB":  1e6,
+	"G":   1 << 30,
+	"GI":  1 << 30,
+	"GIB": 1 << 30,
+	"GB":  1e9,
+	"T":   1 << 40,
+	"TI":  1 << 40,
+	"TIB": 1 << 40,
+	"TB":  1e12,
+}
+
+func (s Size) MarshalJSON() ([]byte, error) {
+	if s == autoPartitionSize {
+		return json.Marshal("auto")
+	}
+	return json.Marshal(int64(s))
+}
+
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		if n < 0 {
+			return fmt.Errorf("size must not be negative: %d", n)
+		}
+		*s = Size(n)
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("size must be a number of bytes or a string: %s", data)
+	}
+
+	size, err := parsePartitionSize(str)
+	if err != nil {
+		return err
+	}
+	*s = size
+
+	return nil
+}
+
+// parsePartitionSize parses "auto" or a size such as "2GiB", "1.5G" or
+// "500MB". Bare K, M, G and T are binary units.
+func parsePartitionSize(str string) (Size, error) {
+	str = strings.TrimSpace(str)
+	if strings.EqualFold(str, "auto") {
+		return autoPartitionSize, nil
+	}
+
+	split := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split == -1 {
+		split = len(str)
+	}
+
+	value, err := strconv.ParseFloat(str[:split], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %q", str)
+	}
+
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(str[split:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit: %q", str)
+	}
+
+	bytes := math.Ceil(value * unit)
+	if bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("size too large: %q", str)
+	}
+
+	return Size(bytes), nil
+}
+
+// partitionBytes returns the partition size of the container, sizing it
+// from the contents of inPath plus its headroom when the size is "auto" or
+// omitted.
+func (cfg Container) partitionBytes(inPath string) (int64, error) {
+	if cfg.Size != autoPartitionSize && cfg.Size != 0 {
+		return int64(cfg.Size), nil
+	}
+
+	headroom := cfg.Headroom
+	if headroom == "" {
+		headroom = defaultHeadroom
+	}
+
+	extra, err := parseHeadroom(headroom)
+	if err != nil {
+		return 0, err
+	}
+
+	return ext3PartitionSize(cfg.Name, inPath, extra)
+}
+
+// parseHeadroom parses a headroom given either as a percentage of the
+// partition size, such as "10%", or as a size, such as "64MiB".
+func parseHeadroom(headroom string) (func(int64) int64, error) {
+	headroom = strings.TrimSpace(headroom)
+
+	if percent := strings.TrimSuffix(headroom, "%"); percent != headroom {
+		value, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid headroom: %q", headroom)
+		}
+		return func(size int64) int64 {
+			return int64(math.Ceil(float64(size) * value / 100))
+		}, nil
+	}
+
+	extra, err := parsePartitionSize(headroom)
+	if err != nil || extra == autoPartitionSize {
+		return nil, fmt.Errorf("invalid headroom: %q", headroom)
+	}
+
+	return func(int64) int64 {
+		return int64(extra)
+	}, nil
+}
@@ -0,0 +1,116 @@
+package workflow
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// schemaPattern returns the pattern found in schema/workflow-v1.schema.json
+// by following the keys, or list indices, of path.
+func schemaPattern(t *testing.T, path ...string) *regexp.Regexp {
+	t.Helper()
+
+	file, err := os.ReadFile(filepath.Join("..", "..", "schema", "workflow-v1.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var node interface{}
+	if err := json.Unmarshal(file, &node); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			node = n[key]
+		case []interface{}:
+			var i int
+			if err := json.Unmarshal([]byte(key), &i); err != nil || i >= len(n) {
+				t.Fatalf("no element %s in the schema", key)
+			}
+			node = n[i]
+		}
+	}
+	pattern, ok := node.(string)
+	if !ok {
+		t.Fatalf("no pattern at %v in the schema", path)
+	}
+	return regexp.MustCompile(pattern)
+}
+
+func TestParsePartitionSize(t *testing.T) {
+	tests := []struct {
+		str  string
+		want Size
+		ok   bool
+	}{
+		{"4096", 4096, true},
+		{" 10 B ", 10, true},
+		{"2K", 2 << 10, true},
+		{"2Ki", 2 << 10, true},
+		{"2KiB", 2 << 10, true},
+		{"2KB", 2000, true},
+		{"1.5G", 3 << 29, true},
+		{"2gi", 2 << 30, true},
+		{"2gib", 2 << 30, true},
+		{"500mb", 500e6, true},
+		{"1T", 1 << 40, true},
+		{".5M", 1 << 19, true},
+		{"1.M", 1 << 20, true},
+		{"auto", autoPartitionSize, true},
+		{"AUTO", autoPartitionSize, true},
+		{"", 0, false},
+		{".", 0, false},
+		{"GiB", 0, false},
+		{"2iB", 0, false},
+		{"2P", 0, false},
+		{"2 G B", 0, false},
+		{"-1G", 0, false},
+		{"1e3", 0, false},
+	}
+
+	schema := schemaPattern(t, "$defs", "size", "oneOf", "1", "pattern")
+	for _, tt := range tests {
+		got, err := parsePartitionSize(tt.str)
+		if ok := err == nil; ok != tt.ok || got != tt.want {
+			t.Errorf("parsePartitionSize(%q) = %d, %v, want %d", tt.str, got, err, tt.want)
+		}
+		if schema.MatchString(tt.str) != tt.ok {
+			t.Errorf("schema size pattern matches %q: %v, want %v", tt.str, !tt.ok, tt.ok)
+		}
+	}
+}
+
+func TestParseHeadroom(t *testing.T) {
+	tests := []struct {
+		str  string
+		want int64
+		ok   bool
+	}{
+		{"10%", 100, true},
+		{" 2.5 % ", 25, true},
+		{"64MiB", 64 << 20, true},
+		{"64mi", 64 << 20, true},
+		{"1kb", 1000, true},
+		{"0", 0, true},
+		{"auto", 0, false},
+		{"%", 0, false},
+		{"ten%", 0, false},
+		{"10%%", 0, false},
+	}
+
+	schema := schemaPattern(t, "$defs", "inputContainer", "properties", "Headroom", "pattern")
+	for _, tt := range tests {
+		extra, err := parseHeadroom(tt.str)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("parseHeadroom(%q) error %v, want ok %v", tt.str, err, tt.ok)
+		} else if ok && extra(1000) != tt.want {
+			t.Errorf("parseHeadroom(%q) adds %d to 1000 bytes, want %d", tt.str, extra(1000), tt.want)
+		}
+		if schema.MatchString(tt.str) != tt.ok {
+			t.Errorf("schema headroom pattern matches %q: %v, want %v", tt.str, !tt.ok, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// planCreate prints what createWorkflow would do without writing anything:
+// the containers it would build or reuse, their sizes, how the runtime would
+// build the application containers and the files it would create or
+// overwrite.
+func (cfg Workflow) planCreate(opts workflowOptions) error {
+	stages, err := cfg.getStages()
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	var files []string
+	seen := make(map[string]bool)
+	for _, stage := range stages {
+		if cfg.multiStage() {
+			fmt.Fprintf(os.Stdout, "Stage: %s\n", stage.WorkflowName)
+		}
+
+		if app := stage.ApplicationContainer; !seen[app.Name] {
+			seen[app.Name] = true
+			buildKey, err := app.appBuildKey()
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("application container %s: %v", app.Name, err))
+			} else if !opts.rebuild && upToDate(app.Name, buildKey) {
+				fmt.Fprintf(os.Stdout, "Unchanged: application container %s\n", app.Name)
+			} else {
+				fmt.Fprintf(os.Stdout, "Build: application container %s: %s\n", app.Name, planBuild(app, opts))
+				files = append(files, app.Name+".sif")
+			}
+		}
+
+		for i, inputContainer := range stage.InputContainer {
+			if inputContainer.Stage != "" {
+				fmt.Fprintf(os.Stdout, "Skipping: input container %d: %s is produced by stage %s\n", i+1, inputContainer.Name, inputContainer.Stage)
+				continue
+			}
+			if seen[inputContainer.Name] {
+				continue
+			}
+			seen[inputContainer.Name] = true
+
+			buildKey, err := inputContainer.inputBuildKey()
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("input container %s: %v", inputContainer.Name, err))
+				continue
+			}
+			if !opts.rebuild && upToDate(inputContainer.Name, buildKey) {
+				fmt.Fprintf(os.Stdout, "Unchanged: input container %d: %s\n", i+1, inputContainer.Name)
+				continue
+			}
+
+			switch inputContainer.Format {
+			case "", formatExt3:
+				size, err := inputContainer.partitionBytes(inputContainer.InPath)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("input container %s: %v", inputContainer.Name, err))
+					continue
+				}
+				fmt.Fprintf(os.Stdout, "Build: input container %d: %s: ext3 partition of %d bytes from %s\n", i+1, inputContainer.Name, size, absPath(inputContainer.InPath))
+			case formatSquashfs:
+				fmt.Fprintf(os.Stdout, "Build: input container %d: %s: squashfs partition from %s\n", i+1, inputContainer.Name, absPath(inputContainer.InPath))
+			default:
+				problems = append(problems, fmt.Sprintf("input container %s: unknown format %q", inputContainer.Name, inputContainer.Format))
+				continue
+			}
+			files = append(files, inputContainer.Name+".sif")
+		}
+
+		for _, outputContainer := range stage.outputContainers() {
+			if seen[outputContainer.Name] {
+				continue
+			}
+			seen[outputContainer.Name] = true
+			if outputContainer.Size == autoPartitionSize || outputContainer.Size == 0 {
+				problems = append(problems, fmt.Sprintf("output container %s needs an explicit Size", outputContainer.Name))
+				continue
+			}
+			fmt.Fprintf(os.Stdout, "Build: output container %s: ext3 partition of %d bytes\n", outputContainer.Name, outputContainer.Size)
+			files = append(files, outputContainer.Name+".sif")
+		}
+	}
+
+	printPlannedFiles(files, "create")
+
+	return planProblems(problems)
+}
+
+// planBuild describes the build of the application container app by the
+// runtime of opts: the command line ApptainerRuntime runs, with the
+// variables it sets, or the def file another runtime is given.
+func planBuild(app Container, opts workflowOptions) string {
+	runtime, ok := opts.containerRuntime().(ApptainerRuntime)
+	if !ok {
+		return fmt.Sprintf("from %s with %T", app.InPath, opts.containerRuntime())
+	}
+
+	command := shellCommand(runtime.buildArgv(app.Name+".sif", app.InPath))
+	if env := runtime.buildEnv(opts.tmpDir); len(env) > 0 {
+		command = shellCommand(env) + " " + command
+	}
+	return command
+}
+
+// planRun prints what execWorkflow would do without running anything: the
+// exact run commands and the files it would modify or create.
+func (cfg Workflow) planRun(opts workflowOptions) error {
+	stages, err := cfg.getStages()
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	var files []string
+	for _, stage := range stages {
+		inv, err := stage.newInvocation()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("stage %s: %v", stage.WorkflowName, err))
+			continue
+		}
+
+		command := shellCommand(inv.argv())
+		if env := inv.containerEnv(); len(env) > 0 {
+			command = shellCommand(env) + " " + command
+		}
+		fmt.Fprintf(os.Stdout, "Run: stage %s: %s\n", stage.WorkflowName, command)
+
+		needed := []string{stage.ApplicationContainer.Name}
+		for _, inputContainer := range stage.InputContainer {
+			if inputContainer.Stage == "" {
+				needed = append(needed, inputContainer.Name)
+			}
+		}
+		for _, outputContainer := range stage.outputContainers() {
+			needed = append(needed, outputContainer.Name)
+			files = append(files, outputContainer.Name+".sif")
+		}
+		for _, name := range needed {
+			if _, err := os.Stat(name + ".sif"); err != nil {
+				problems = append(problems, fmt.Sprintf("stage %s: %s.sif does not exist, create the workflow first", stage.WorkflowName, name))
+			}
+		}
+	}
+
+	if cfg.Sweep != nil {
+		files = append(files, cfg.WorkflowName+"_sweep.json")
+	}
+
+	printPlannedFiles(files, "run")
+
+	return planProblems(problems)
+}
+
+// printPlannedFiles lists the files a plan writes, marking those that exist.
+func printPlannedFiles(files []string, action string) {
+	for _, file := range files {
+		state := "new"
+		if _, err := os.Stat(file); err == nil {
+			state = "overwritten"
+			if action == "run" && strings.HasSuffix(file, ".sif") {
+				state = "annotated"
+			}
+		}
+		fmt.Fprintf(os.Stdout, "File: %s (%s)\n", absPath(file), state)
+	}
+}
+
+func planProblems(problems []string) error {
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stdout, "Problem: %s\n", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("dry run found %d problems", len(problems))
+	}
+
+	fmt.Fprintln(os.Stdout, "dry run found no problems, nothing was written")
+
+	return nil
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// shellCommand quotes argv as it would be typed in a shell.
+func shellCommand(argv []string) string {
+	quoted := make([]string, 0, len(argv))
+	for _, arg := range argv {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
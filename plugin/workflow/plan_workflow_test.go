@@ -0,0 +1,23 @@
+package workflow
+
+import "testing"
+
+func TestPlanBuild(t *testing.T) {
+	app := Container{Name: "app", InPath: "defs/app.def"}
+
+	tests := []struct {
+		name string
+		opts workflowOptions
+		want string
+	}{
+		{"apptainer", workflowOptions{}, "apptainer build --fakeroot --force app.sif defs/app.def"},
+		{"tmpdir", workflowOptions{tmpDir: "/scratch"}, "APPTAINER_TMPDIR=/scratch apptainer build --fakeroot --force app.sif defs/app.def"},
+		{"other runtime", workflowOptions{runtime: &FakeRuntime{}}, "from defs/app.def with *workflow.FakeRuntime"},
+	}
+
+	for _, tt := range tests {
+		if got := planBuild(app, tt.opts); got != tt.want {
+			t.Errorf("%s: planBuild = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
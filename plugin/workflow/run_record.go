@@ -0,0 +1,71 @@
+package workflow
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// RunRecord describes one execution of a workflow application: when and
+// where it ran, as whom, with which command line, and how it ended.
+type RunRecord struct {
+	StartTime        time.Time
+	EndTime          time.Time
+	WallTime         float64
+	ExitStatus       int
+	Error            string `json:",omitempty"`
+	Hostname         string
+	Username         string
+	Kernel           string
+	ApptainerVersion string
+	Argv             []string
+}
+
+// newRunRecord records the host facts of a run of argv by runtime that
+// starts now.
+func newRunRecord(argv []string, runtime Runtime) *RunRecord {
+	record := &RunRecord{
+		StartTime:        time.Now(),
+		Argv:             argv,
+		Kernel:           kernelRelease(),
+		ApptainerVersion: runtime.Version(),
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		record.Hostname = hostname
+	}
+
+	if u, err := user.Current(); err == nil {
+		record.Username = u.Username
+	}
+
+	return record
+}
+
+// finish records the end of the run and its outcome, err being the error
+// returned by the command, if any.
+func (record *RunRecord) finish(err error) {
+	record.EndTime = time.Now()
+	record.WallTime = record.EndTime.Sub(record.StartTime).Seconds()
+
+	if err == nil {
+		return
+	}
+
+	record.Error = err.Error()
+	record.ExitStatus = -1
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		record.ExitStatus = exitErr.ExitCode()
+	}
+}
+
+func kernelRelease() string {
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(release))
+}
@@ -0,0 +1,318 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/apptainer/sif/v2/pkg/sif"
+	uuid "github.com/satori/go.uuid"
+)
+
+func execWorkflow(path string, opts workflowOptions) error {
+	cfg, err := loadWorkflow(path, false)
+	if err != nil {
+		return err
+	}
+
+	return cfg.run(context.Background(), opts)
+}
+
+// run runs the stages of the workflow. Cancelling ctx kills the running
+// applications and starts no more.
+func (cfg Workflow) run(ctx context.Context, opts workflowOptions) error {
+	if opts.dryRun {
+		return cfg.planRun(opts)
+	}
+
+	stages, err := cfg.getStages()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Sweep != nil {
+		// every parameter combination is run even if some fail
+		opts.keepGoing = true
+	}
+
+	statuses := executeStages(ctx, stages, opts)
+	if cfg.multiStage() {
+		reportStatuses(statuses)
+	}
+
+	if cfg.Sweep != nil {
+		path, err := cfg.writeSweepIndex(stages, statuses)
+		if err != nil {
+			return fmt.Errorf("error writing sweep index: %v", err)
+		}
+		fmt.Fprintf(os.Stdout, "Sweep index: %s\n", path)
+	}
+
+	// report the first failure or, when no stage failed, the first stage
+	// that was skipped or cancelled
+	failed := 0
+	var firstErr error
+	firstFailed := false
+	for _, status := range statuses {
+		if status.state == runSucceeded {
+			continue
+		}
+		failed++
+		if firstFailed || (firstErr != nil && status.state != runFailed) {
+			continue
+		}
+		firstFailed = status.state == runFailed
+
+		switch {
+		case status.state != runFailed:
+			firstErr = fmt.Errorf("stage %s %s: %v", status.name, status.state, status.err)
+		case cfg.multiStage():
+			firstErr = fmt.Errorf("error running stage %s: %v", status.name, status.err)
+		default:
+			firstErr = status.err
+		}
+	}
+
+	if failed > 1 {
+		return fmt.Errorf("%d of %d stages did not succeed: %v", failed, len(statuses), firstErr)
+	} else if failed == 1 {
+		return firstErr
+	}
+
+	return nil
+}
+
+// runStage runs the application of a single stage workflow and annotates
+// its output containers. Cancelling ctx kills the application.
+func (cfg Workflow) runStage(ctx context.Context, opts workflowOptions) error {
+	if opts.requireSigned {
+		if err := cfg.checkSignatures(opts); err != nil {
+			return err
+		}
+	}
+
+	inv, err := cfg.newInvocation()
+	if err != nil {
+		return err
+	}
+
+	stdout := newCapturedOutput("stdout.log", outputCaptureLimit)
+	stderr := newCapturedOutput("stderr.log", outputCaptureLimit)
+
+	runtime := opts.containerRuntime()
+	record := newRunRecord(inv.argv(), runtime)
+	runErr := runtime.Run(ctx, inv, io.MultiWriter(os.Stdout, stdout), io.MultiWriter(os.Stderr, stderr))
+	record.finish(runErr)
+
+	// Output containers are annotated even when the run fails, so that the
+	// failure is recorded with whatever the application wrote.
+	if err := cfg.annotateOutputs(ctx, inv, record, stdout, stderr, opts); err != nil {
+		return err
+	}
+
+	return runErr
+}
+
+// annotateOutputs adds the metadata of a run to every output container of
+// the stage and signs it.
+func (cfg Workflow) annotateOutputs(ctx context.Context, inv Invocation, record *RunRecord, stdout, stderr *capturedOutput, opts workflowOptions) error {
+	for _, outputContainer := range cfg.outputContainers() {
+		if err := cfg.annotateOutputContainer(ctx, outputContainer, inv, record, stdout, stderr, opts); err != nil {
+			return fmt.Errorf("error annotating output container %s: %v", outputContainer.Name, err)
+		}
+		if err := opts.signContainer(outputContainer.Name); err != nil {
+			return fmt.Errorf("error signing output container %s: %v", outputContainer.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkSignatures refuses to run the stage unless its application and input
+// containers are signed by the verification key.
+func (cfg Workflow) checkSignatures(opts workflowOptions) error {
+	names := []string{cfg.ApplicationContainer.Name}
+	for _, inputContainer := range cfg.InputContainer {
+		names = append(names, inputContainer.Name)
+	}
+
+	for _, name := range names {
+		if err := opts.verifyContainerSignature(name + ".sif"); err != nil {
+			return fmt.Errorf("refusing to run: container %s is not validly signed: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (cfg Workflow) annotateOutputContainer(ctx context.Context, outputContainer Container, inv Invocation, record *RunRecord, stdout, stderr *capturedOutput, opts workflowOptions) error {
+	path := outputContainer.Name + ".sif"
+
+	rt, err := cfg.getRecordTrail(outputContainer)
+	if err != nil {
+		return err
+	}
+
+	digest, err := getContentDigest(ctx, path, opts.tmpDir)
+	if err != nil {
+		return fmt.Errorf("error computing content digest: %v", err)
+	}
+
+	outputContainerImg, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDWR))
+	if err != nil {
+		return err
+	}
+
+	cmd, err := cfg.getRunscript(opts.containerRuntime())
+	if err != nil {
+		return err
+	}
+
+	containerUuid, err := uuid.FromString(outputContainerImg.ID())
+	if err != nil {
+		return err
+	}
+
+	stdoutLog, err := stdout.addTo(outputContainerImg)
+	if err != nil {
+		return err
+	}
+
+	stderrLog, err := stderr.addTo(outputContainerImg)
+	if err != nil {
+		return err
+	}
+
+	metadata := Metadata{
+		UUID:             containerUuid,
+		Name:             outputContainer.Name,
+		CreationTime:     outputContainerImg.CreatedAt(),
+		ExecutionCommand: cmd,
+		Args:             inv.Args,
+		Env:              inv.Env,
+		Parameters:       cfg.parameters,
+		Invocation:       &inv,
+		RecordTrail:      &rt,
+		ContentDigest:    digest,
+		RunRecord:        record,
+		Stdout:           stdoutLog,
+		Stderr:           stderrLog,
+	}
+
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	metadataDescription, err := sif.NewDescriptorInput(sif.DataGenericJSON, bytes.NewReader(metadataJSON), sif.OptObjectName("metadata.json"))
+	if err != nil {
+		return err
+	}
+
+	if err := outputContainerImg.AddObject(metadataDescription); err != nil {
+		return err
+	}
+
+	if err := outputContainerImg.UnloadContainer(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getRecordTrail builds the record trail of one output container, listing
+// the other output containers of the same run as its siblings.
+func (cfg Workflow) getRecordTrail(outputContainer Container) (RecordTrail, error) {
+	rt := RecordTrail{}
+
+	rt.InputContainers = make([]ContainerRef, 0)
+	for _, inputContainer := range cfg.InputContainer {
+		ref, err := loadContainerRef(inputContainer.Name)
+		if err != nil {
+			return rt, err
+		}
+		ref.Stage = inputContainer.Stage
+		rt.InputContainers = append(rt.InputContainers, ref)
+	}
+
+	applicationRef, err := loadContainerRef(cfg.ApplicationContainer.Name)
+	if err != nil {
+		return rt, err
+	}
+	rt.ApplicationContainer = &applicationRef
+
+	outputRef, err := loadContainerRef(outputContainer.Name)
+	if err != nil {
+		return rt, err
+	}
+	rt.OutputContainer = &outputRef
+
+	for _, sibling := range cfg.outputContainers() {
+		if sibling.Name == outputContainer.Name {
+			continue
+		}
+		siblingRef, err := loadContainerRef(sibling.Name)
+		if err != nil {
+			return rt, err
+		}
+		rt.SiblingOutputs = append(rt.SiblingOutputs, siblingRef)
+	}
+
+	return rt, nil
+}
+
+// loadContainerRef reads the UUID of the container image name.sif.
+func loadContainerRef(name string) (ContainerRef, error) {
+	ref := ContainerRef{Name: name}
+
+	img, err := sif.LoadContainerFromPath(name+".sif", sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return ref, err
+	}
+
+	containerUuid, err := uuid.FromString(img.ID())
+	if err != nil {
+		return ref, err
+	}
+	ref.UUID = containerUuid
+
+	if err := img.UnloadContainer(); err != nil {
+		return ref, err
+	}
+
+	return ref, nil
+}
+
+// getRunscript returns the runscript of the application container.
+func (cfg Workflow) getRunscript(runtime Runtime) (string, error) {
+	defFile, err := runtime.Inspect(cfg.ApplicationContainer.Name + ".sif")
+	if err != nil {
+		return "", err
+	}
+
+	return extractRunscript(defFile), nil
+}
+
+func extractRunscript(str string) string {
+	start := strings.Index(str, "%runscript")
+	if start == -1 {
+		return ""
+	}
+	start += len("%runscript")
+
+	str = str[start:]
+
+	end := strings.Index(str, "%")
+	if end != -1 {
+		str = str[:end]
+	}
+
+	str = strings.ReplaceAll(str, "\n", " ")
+	str = strings.Trim(str, " ")
+
+	return str
+}
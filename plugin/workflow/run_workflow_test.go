@@ -0,0 +1,318 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// runCalls returns the invocations of the Run calls recorded by fake, in
+// order.
+func runCalls(fake *FakeRuntime) []Invocation {
+	var invocations []Invocation
+	for _, call := range fake.Calls() {
+		if call.Method == "Run" {
+			invocations = append(invocations, *call.Invocation)
+		}
+	}
+	return invocations
+}
+
+func readTestMetadata(t *testing.T, name string) Metadata {
+	t.Helper()
+
+	metadata, err := ReadMetadata(name + ".sif")
+	if err != nil {
+		t.Fatalf("reading metadata of %s: %v", name, err)
+	}
+	return metadata
+}
+
+// checkRef fails unless ref names the container image name.sif.
+func checkRef(t *testing.T, what string, ref *ContainerRef, name string) {
+	t.Helper()
+
+	if ref == nil {
+		t.Errorf("%s: missing", what)
+		return
+	}
+	if ref.Name != name {
+		t.Errorf("%s: name %s, want %s", what, ref.Name, name)
+	}
+	if want := readTestMetadata(t, name).UUID; ref.UUID != want {
+		t.Errorf("%s: UUID %s, want that of %s.sif, %s", what, ref.UUID, name, want)
+	}
+}
+
+func TestCreateAndRunSingleStage(t *testing.T) {
+	wf := testWorkflow(t)
+	wf.Flags = []string{"--cleanenv", "--env-file=vars"}
+	wf.Args = []string{"--verbose"}
+	wf.Env = map[string]string{"MODE": "test"}
+	ctx := context.Background()
+
+	fake := &FakeRuntime{
+		Application: func(ctx context.Context, root string, inv Invocation, stdout, stderr io.Writer) error {
+			input, err := os.ReadFile(filepath.Join(root, "data", "data", "input.txt"))
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(stdout, "read %s", input)
+			return err
+		},
+	}
+
+	if err := Create(ctx, wf, WithRuntime(fake)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	var builds []FakeCall
+	for _, call := range fake.Calls() {
+		if call.Method == "Build" {
+			builds = append(builds, call)
+		}
+	}
+	if len(builds) != 1 || builds[0].Image != "app.sif" || builds[0].DefPath != "app.def" {
+		t.Errorf("builds = %+v, want app.sif from app.def", builds)
+	}
+	for _, name := range []string{"data", "results"} {
+		if _, err := os.Stat(name + ".sif"); err != nil {
+			t.Errorf("container %s not created: %v", name, err)
+		}
+	}
+
+	if err := Run(ctx, wf, WithRuntime(fake)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	runs := runCalls(fake)
+	if len(runs) != 1 {
+		t.Fatalf("%d runs, want 1", len(runs))
+	}
+	wantInv := Invocation{
+		Binary:  "apptainer",
+		Command: "run",
+		Flags:   []string{"--cleanenv", "--env-file=vars"},
+		Binds: []BindMount{
+			{Source: "data.sif", Destination: "/data", ImageSrc: "/data", ReadOnly: true},
+			{Source: "results.sif", Destination: "/results", ImageSrc: "/results"},
+		},
+		Env:   map[string]string{"MODE": "test"},
+		Image: "app.sif",
+		Args:  []string{"--verbose"},
+	}
+	if !reflect.DeepEqual(runs[0], wantInv) {
+		t.Errorf("invocation = %+v, want %+v", runs[0], wantInv)
+	}
+	if spec := runs[0].Binds[0].String(); spec != "data.sif:/data:image-src=/data,ro" {
+		t.Errorf("ext3 input bound as %s, want it read-only", spec)
+	}
+
+	metadata := readTestMetadata(t, "results")
+	if metadata.Name != "results" {
+		t.Errorf("name = %s, want results", metadata.Name)
+	}
+	if !reflect.DeepEqual(metadata.Invocation, &wantInv) {
+		t.Errorf("recorded invocation = %+v, want %+v", metadata.Invocation, wantInv)
+	}
+	if want := "cat /data/data/input.txt > /results/output.txt"; metadata.ExecutionCommand != want {
+		t.Errorf("execution command = %q, want the runscript %q", metadata.ExecutionCommand, want)
+	}
+
+	record := metadata.RunRecord
+	if record == nil {
+		t.Fatal("no run record")
+	}
+	if record.ExitStatus != 0 || record.Error != "" {
+		t.Errorf("run record exit status %d, error %q, want a success", record.ExitStatus, record.Error)
+	}
+	if record.ApptainerVersion != "fake" {
+		t.Errorf("run record version = %q, want that of the runtime", record.ApptainerVersion)
+	}
+	if !reflect.DeepEqual(record.Argv, wantInv.argv()) {
+		t.Errorf("run record argv = %q, want %q", record.Argv, wantInv.argv())
+	}
+	if record.EndTime.Before(record.StartTime) {
+		t.Errorf("run ended at %v, before it started at %v", record.EndTime, record.StartTime)
+	}
+
+	if want := int64(len("read 1,2,3\n")); metadata.Stdout == nil || metadata.Stdout.Size != want {
+		t.Errorf("stdout log = %+v, want %d bytes", metadata.Stdout, want)
+	}
+
+	trail := metadata.RecordTrail
+	if trail == nil {
+		t.Fatal("no record trail")
+	}
+	checkRef(t, "application container", trail.ApplicationContainer, "app")
+	checkRef(t, "output container", trail.OutputContainer, "results")
+	if len(trail.InputContainers) != 1 {
+		t.Fatalf("%d input containers in the record trail, want 1", len(trail.InputContainers))
+	}
+	checkRef(t, "input container", &trail.InputContainers[0], "data")
+}
+
+func TestRunMultiStageBindsUpstreamOutput(t *testing.T) {
+	chdirTemp(t)
+	writeTestFile(t, "app.def", testDefFile)
+	writeTestFile(t, "data/input.txt", "1,2,3\n")
+	wf := Workflow{
+		WorkflowName: "test_stages",
+		Flags:        []string{"--cleanenv"},
+		Stages: []Stage{
+			{
+				Name:                 "analyse",
+				ApplicationContainer: Container{Name: "app", InPath: "app.def"},
+				InputContainer:       []Container{{Stage: "prepare"}},
+				OutputContainer:      Container{Name: "results", Size: 4 << 20},
+				Args:                 []string{"analyse"},
+			},
+			{
+				Name:                 "prepare",
+				ApplicationContainer: Container{Name: "app", InPath: "app.def"},
+				InputContainer:       []Container{{Name: "data", InPath: "data"}},
+				OutputContainer:      Container{Name: "prepared", Size: 4 << 20},
+				Flags:                []string{"--nv"},
+				Args:                 []string{"prepare"},
+			},
+		},
+	}
+	fake := &FakeRuntime{}
+	ctx := context.Background()
+
+	if err := Create(ctx, wf, WithRuntime(fake)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := Run(ctx, wf, WithRuntime(fake)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	runs := runCalls(fake)
+	if len(runs) != 2 {
+		t.Fatalf("%d runs, want 2", len(runs))
+	}
+	if runs[0].Args[0] != "prepare" || runs[1].Args[0] != "analyse" {
+		t.Fatalf("stages run in the order %s, %s, want prepare before analyse", runs[0].Args[0], runs[1].Args[0])
+	}
+	if !reflect.DeepEqual(runs[0].Flags, []string{"--nv"}) || !reflect.DeepEqual(runs[1].Flags, []string{"--cleanenv"}) {
+		t.Errorf("flags = %q, %q, want the prepare stage's own and the workflow's", runs[0].Flags, runs[1].Flags)
+	}
+	wantBinds := []BindMount{
+		{Source: "prepared.sif", Destination: "/prepared", ImageSrc: "/prepared", ReadOnly: true},
+		{Source: "results.sif", Destination: "/results", ImageSrc: "/results"},
+	}
+	if !reflect.DeepEqual(runs[1].Binds, wantBinds) {
+		t.Errorf("analyse binds = %+v, want %+v", runs[1].Binds, wantBinds)
+	}
+	if spec := runs[1].Binds[0].String(); spec != "prepared.sif:/prepared:image-src=/prepared,ro" {
+		t.Errorf("output of the prepare stage bound as %s, want it read-only", spec)
+	}
+
+	trail := readTestMetadata(t, "results").RecordTrail
+	if trail == nil || len(trail.InputContainers) != 1 {
+		t.Fatalf("record trail = %+v, want one input container", trail)
+	}
+	input := trail.InputContainers[0]
+	checkRef(t, "input container", &input, "prepared")
+	if input.Stage != "prepare" {
+		t.Errorf("input container stage = %q, want prepare", input.Stage)
+	}
+
+	prepared := readTestMetadata(t, "prepared")
+	if prepared.RunRecord == nil || !reflect.DeepEqual(prepared.Args, []string{"prepare"}) {
+		t.Errorf("prepared metadata = %+v, want the record of the prepare stage", prepared)
+	}
+}
+
+func TestRunSweep(t *testing.T) {
+	wf := testWorkflow(t)
+	wf.Args = []string{"--k", "{k}"}
+	wf.Sweep = &Sweep{Grid: map[string][]SweepValue{"k": {"1", "2"}}}
+	fake := &FakeRuntime{}
+	ctx := context.Background()
+
+	if err := Create(ctx, wf, WithRuntime(fake)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := Run(ctx, wf, WithRuntime(fake)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	var args []string
+	for _, inv := range runCalls(fake) {
+		args = append(args, inv.Args[1])
+		if len(inv.Binds) != 2 {
+			t.Errorf("%d binds, want the input and the output container", len(inv.Binds))
+			continue
+		}
+		output := inv.Binds[1]
+		if want := "results_k-" + inv.Args[1] + ".sif"; output.Source != want || output.Destination != "/results" {
+			t.Errorf("output bind = %+v, want %s at /results", output, want)
+		}
+	}
+	sort.Strings(args)
+	if !reflect.DeepEqual(args, []string{"1", "2"}) {
+		t.Errorf("runs with k = %q, want 1 and 2", args)
+	}
+
+	for _, k := range []string{"1", "2"} {
+		name := "results_k-" + k
+		metadata := readTestMetadata(t, name)
+		if !reflect.DeepEqual(metadata.Parameters, map[string]string{"k": k}) {
+			t.Errorf("%s parameters = %v, want k=%s", name, metadata.Parameters, k)
+		}
+		if !reflect.DeepEqual(metadata.Args, []string{"--k", k}) {
+			t.Errorf("%s args = %q, want --k %s", name, metadata.Args, k)
+		}
+		if metadata.RecordTrail != nil {
+			checkRef(t, name+" output container", metadata.RecordTrail.OutputContainer, name)
+		}
+	}
+
+	if _, err := os.Stat("test_workflow_sweep.json"); err != nil {
+		t.Errorf("sweep index not written: %v", err)
+	}
+}
+
+func TestRunRecordsFailingApplication(t *testing.T) {
+	wf := testWorkflow(t)
+	ctx := context.Background()
+
+	fake := &FakeRuntime{
+		Application: func(ctx context.Context, root string, inv Invocation, stdout, stderr io.Writer) error {
+			fmt.Fprint(stderr, "bad input\n")
+			return FakeExitStatus(3)
+		},
+	}
+
+	if err := Create(ctx, wf, WithRuntime(fake)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	err := Run(ctx, wf, WithRuntime(fake))
+	var exitErr interface{ ExitCode() int }
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("run error = %v, want exit status 3", err)
+	}
+
+	metadata := readTestMetadata(t, "results")
+	record := metadata.RunRecord
+	if record == nil {
+		t.Fatal("failed run not recorded")
+	}
+	if record.ExitStatus != 3 || record.Error != "exit status 3" {
+		t.Errorf("run record exit status %d, error %q, want 3, exit status 3", record.ExitStatus, record.Error)
+	}
+	if want := int64(len("bad input\n")); metadata.Stderr == nil || metadata.Stderr.Size != want {
+		t.Errorf("stderr log = %+v, want %d bytes", metadata.Stderr, want)
+	}
+	if metadata.Stdout == nil || metadata.Stdout.Size != 0 {
+		t.Errorf("stdout log = %+v, want an empty log", metadata.Stdout)
+	}
+}
@@ -0,0 +1,122 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/apptainer/sif/v2/pkg/sif"
+)
+
+// Runtime is the container runtime that builds application containers and
+// runs workflow applications. Input and output containers are built in
+// process and do not depend on it.
+type Runtime interface {
+	// Build builds the container image at image from the def file at
+	// defPath, keeping its temporary files under tmpDir, if set.
+	Build(ctx context.Context, image, defPath, tmpDir string) error
+	// Run runs inv, copying the output of the application to stdout and
+	// stderr. An error with an ExitCode method reports the exit status of
+	// the application.
+	Run(ctx context.Context, inv Invocation, stdout, stderr io.Writer) error
+	// Inspect returns the def file the container image at image was built
+	// from.
+	Inspect(image string) (string, error)
+	// Version returns the name and version of the runtime, or an empty
+	// string if they are unknown.
+	Version() string
+}
+
+// ApptainerRuntime is the default Runtime, which runs the apptainer binary
+// found in $PATH.
+type ApptainerRuntime struct{}
+
+func (r ApptainerRuntime) Build(ctx context.Context, image, defPath, tmpDir string) error {
+	argv := r.buildArgv(image, defPath)
+	build := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	if env := r.buildEnv(tmpDir); len(env) > 0 {
+		build.Env = append(os.Environ(), env...)
+	}
+
+	return build.Run()
+}
+
+// buildArgv returns the command line that builds image from the def file at
+// defPath.
+func (ApptainerRuntime) buildArgv(image, defPath string) []string {
+	return []string{
+		"apptainer",
+		"build",
+		"--fakeroot",
+		"--force",
+		image,
+		defPath,
+	}
+}
+
+// buildEnv returns the variables a build sets on top of the current
+// environment to keep its temporary files under tmpDir, if set.
+func (ApptainerRuntime) buildEnv(tmpDir string) []string {
+	if tmpDir == "" {
+		return nil
+	}
+	return []string{"APPTAINER_TMPDIR=" + tmpDir}
+}
+
+func (ApptainerRuntime) Run(ctx context.Context, inv Invocation, stdout, stderr io.Writer) error {
+	run := inv.command(ctx)
+	run.Stdout = stdout
+	run.Stderr = stderr
+
+	return run.Run()
+}
+
+// Inspect reads the def file that apptainer stores in the image.
+func (ApptainerRuntime) Inspect(image string) (string, error) {
+	return readDefFile(image)
+}
+
+func (ApptainerRuntime) Version() string {
+	version, err := exec.Command("apptainer", "--version").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(version))
+}
+
+// containerRuntime returns the runtime set in opts, or ApptainerRuntime.
+func (opts workflowOptions) containerRuntime() Runtime {
+	if opts.runtime == nil {
+		return ApptainerRuntime{}
+	}
+	return opts.runtime
+}
+
+// readDefFile returns the def files stored in the container image at path,
+// one after another.
+func readDefFile(path string) (string, error) {
+	img, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return "", err
+	}
+	defer img.UnloadContainer()
+
+	descriptors, err := img.GetDescriptors(sif.WithDataType(sif.DataDeffile))
+	if err != nil {
+		return "", fmt.Errorf("could not retrieve container descriptions: %v", err)
+	}
+
+	var defFiles []string
+	for _, descriptor := range descriptors {
+		data, err := descriptor.GetData()
+		if err != nil {
+			return "", err
+		}
+		defFiles = append(defFiles, string(data))
+	}
+
+	return strings.Join(defFiles, "\n"), nil
+}
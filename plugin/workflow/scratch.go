@@ -0,0 +1,79 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// interruptCleanup holds the temporary directories and partly written
+// container images to remove if the workflow CLI is interrupted.
+var interruptCleanup = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// newScratchDir creates a directory of its own for one build under root, or
+// under the system temporary directory if root is empty. The returned
+// function removes it, and it is also removed if the workflow CLI is
+// interrupted first.
+func newScratchDir(root, pattern string) (string, func(), error) {
+	if root != "" {
+		if err := os.MkdirAll(root, 0755); err != nil {
+			return "", nil, fmt.Errorf("error creating scratch root: %v", err)
+		}
+	}
+
+	dir, err := os.MkdirTemp(root, pattern)
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating tmp directory: %v", err)
+	}
+
+	unregister := removeOnInterrupt(dir)
+	return dir, func() {
+		os.RemoveAll(dir)
+		unregister()
+	}, nil
+}
+
+// removeOnInterrupt removes path if the workflow CLI is interrupted before
+// the returned function is called.
+func removeOnInterrupt(path string) func() {
+	interruptCleanup.Lock()
+	interruptCleanup.paths[path] = true
+	interruptCleanup.Unlock()
+
+	return func() {
+		interruptCleanup.Lock()
+		delete(interruptCleanup.paths, path)
+		interruptCleanup.Unlock()
+	}
+}
+
+// handleInterrupts removes the registered paths and exits when the workflow
+// CLI receives SIGINT or SIGTERM, until the returned function is called.
+func handleInterrupts() func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			interruptCleanup.Lock()
+			for path := range interruptCleanup.paths {
+				os.RemoveAll(path)
+			}
+			fmt.Fprintf(os.Stderr, "Interrupted: removed %d temporary files and directories\n", len(interruptCleanup.paths))
+			os.Exit(128 + int(sig.(syscall.Signal)))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
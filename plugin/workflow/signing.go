@@ -0,0 +1,135 @@
+package workflow
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+
+	"github.com/apptainer/sif/v2/pkg/integrity"
+	"github.com/apptainer/sif/v2/pkg/sif"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// workflowOptions holds the settings of a workflow operation that are not
+// part of the workflow description.
+type workflowOptions struct {
+	// signer, when set, signs every container created or annotated.
+	signer signature.Signer
+	// verifier checks container signatures.
+	verifier signature.Verifier
+	// requireSigned refuses to run containers not signed by verifier.
+	requireSigned bool
+	// jobs is the number of stages run at the same time.
+	jobs int
+	// keepGoing runs every stage not depending on a failed stage, instead
+	// of stopping at the first failure.
+	keepGoing bool
+	// rebuild builds every container, even those whose sources have not
+	// changed.
+	rebuild bool
+	// tmpDir is the directory under which builds stage their files, the
+	// system temporary directory if empty.
+	tmpDir string
+	// dryRun prints what would be created or run instead of doing it.
+	dryRun bool
+	// runtime builds and runs the containers, ApptainerRuntime if nil.
+	runtime Runtime
+}
+
+// newWorkflowOptions loads the PEM encoded private key at signKeyPath and
+// public key at verifyKeyPath, either of which may be empty.
+func newWorkflowOptions(signKeyPath, verifyKeyPath string, requireSigned bool) (workflowOptions, error) {
+	var options []Option
+
+	if signKeyPath != "" {
+		signer, err := signature.LoadSignerFromPEMFile(signKeyPath, crypto.SHA256, cryptoutils.SkipPassword)
+		if err != nil {
+			return workflowOptions{}, fmt.Errorf("error loading signing key %s: %v", signKeyPath, err)
+		}
+		options = append(options, WithSigner(signer))
+	}
+
+	if verifyKeyPath != "" {
+		verifier, err := signature.LoadVerifierFromPEMFile(verifyKeyPath, crypto.SHA256)
+		if err != nil {
+			return workflowOptions{}, fmt.Errorf("error loading verification key %s: %v", verifyKeyPath, err)
+		}
+		options = append(options, WithVerifier(verifier))
+	}
+
+	if requireSigned {
+		options = append(options, WithRequireSigned())
+	}
+
+	return newOptions(options)
+}
+
+// signContainer signs every object group of the container image name.sif,
+// including its metadata, replacing any earlier signatures. It does nothing
+// when no signing key is set.
+func (opts workflowOptions) signContainer(name string) error {
+	if opts.signer == nil {
+		return nil
+	}
+
+	img, err := sif.LoadContainerFromPath(name+".sif", sif.OptLoadWithFlag(os.O_RDWR))
+	if err != nil {
+		return err
+	}
+
+	signatures, err := img.GetDescriptors(sif.WithDataType(sif.DataSignature))
+	if err != nil {
+		img.UnloadContainer()
+		return err
+	}
+	// Output containers gain objects after the signature of their creation,
+	// so stale signatures are usually not the last objects and cannot be
+	// compacted away.
+	for _, sig := range signatures {
+		if err := img.DeleteObject(sig.ID(), sif.OptDeleteZero(true)); err != nil {
+			img.UnloadContainer()
+			return fmt.Errorf("error removing stale signature: %v", err)
+		}
+	}
+
+	// the deletions only take effect once the image is written back
+	if len(signatures) > 0 {
+		if err := img.UnloadContainer(); err != nil {
+			return err
+		}
+		if img, err = sif.LoadContainerFromPath(name+".sif", sif.OptLoadWithFlag(os.O_RDWR)); err != nil {
+			return err
+		}
+	}
+
+	signer, err := integrity.NewSigner(img, integrity.OptSignWithSigner(opts.signer))
+	if err != nil {
+		img.UnloadContainer()
+		return err
+	}
+
+	if err := signer.Sign(); err != nil {
+		img.UnloadContainer()
+		return fmt.Errorf("error signing %s: %v", name, err)
+	}
+
+	return img.UnloadContainer()
+}
+
+// verifyContainerSignature checks that every object group of the container
+// image at path is signed by the verification key.
+func (opts workflowOptions) verifyContainerSignature(path string) error {
+	img, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return err
+	}
+	defer img.UnloadContainer()
+
+	verifier, err := integrity.NewVerifier(img, integrity.OptVerifyWithVerifier(opts.verifier))
+	if err != nil {
+		return err
+	}
+
+	return verifier.Verify()
+}
@@ -0,0 +1,79 @@
+package workflow
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+func newTestSignerVerifier(t *testing.T) signature.SignerVerifier {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sv, err := signature.LoadECDSASignerVerifier(key, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sv
+}
+
+func TestSignedCreateAndRun(t *testing.T) {
+	wf := testWorkflow(t)
+	sv := newTestSignerVerifier(t)
+	fake := &FakeRuntime{}
+	ctx := context.Background()
+
+	if err := Create(ctx, wf, WithRuntime(fake), WithSigner(sv)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// the output container gains objects after the signature made at
+	// create, which each run must replace
+	for i := 0; i < 2; i++ {
+		if err := Run(ctx, wf, WithRuntime(fake), WithSigner(sv), WithVerifier(sv), WithRequireSigned()); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	opts := workflowOptions{verifier: sv}
+	for _, name := range []string{"app", "data", "results"} {
+		if err := opts.verifyContainerSignature(name + ".sif"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestRunRefusesContainersSignedByAnotherKey(t *testing.T) {
+	wf := testWorkflow(t)
+	fake := &FakeRuntime{}
+	ctx := context.Background()
+
+	if err := Create(ctx, wf, WithRuntime(fake), WithSigner(newTestSignerVerifier(t))); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	err := Run(ctx, wf, WithRuntime(fake), WithVerifier(newTestSignerVerifier(t)), WithRequireSigned())
+	if err == nil {
+		t.Fatal("run succeeded with containers signed by another key")
+	}
+	for _, call := range fake.Calls() {
+		if call.Method == "Run" {
+			t.Errorf("application run despite invalid signatures")
+		}
+	}
+}
+
+func TestRequireSignedNeedsVerifier(t *testing.T) {
+	if _, err := newOptions([]Option{WithRequireSigned()}); err == nil {
+		t.Error("requiring signed containers without a verifier succeeded")
+	}
+}
@@ -0,0 +1,66 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const (
+	formatExt3     = "ext3"
+	formatSquashfs = "squashfs"
+)
+
+// createSquashfsPartition returns a compressed, read-only squashfs partition
+// whose root holds the directory cfg.Name with a copy of inPath, if any,
+// inside it, laid out like the ext3 partition of an input container. The
+// files are staged in a directory of its own under scratch, and the external
+// tools are killed if ctx is done first.
+func (cfg Container) createSquashfsPartition(ctx context.Context, inPath, scratch string) (io.Reader, error) {
+	stagingDir, cleanup, err := newScratchDir(scratch, "squashfs_"+cfg.Name+"_")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	stagingPath := filepath.Join(stagingDir, cfg.Name)
+	if err := os.Mkdir(stagingPath, 0755); err != nil {
+		return nil, fmt.Errorf("error creating tmp directory: %v", err)
+	}
+
+	if inPath != "" {
+		if err := exec.CommandContext(
+			ctx,
+			"cp",
+			"-r",
+			inPath,
+			stagingPath,
+		).Run(); err != nil {
+			return nil, fmt.Errorf("error copying input files: %v", err)
+		}
+	}
+
+	imagePath := filepath.Join(stagingDir, cfg.Name+".squashfs")
+	if out, err := exec.CommandContext(
+		ctx,
+		"mksquashfs",
+		stagingPath,
+		imagePath,
+		"-keep-as-directory",
+		"-noappend",
+		"-no-progress",
+	).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("error creating squashfs filesystem: %v: %s", err, bytes.TrimSpace(out))
+	}
+
+	partition, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(partition), nil
+}
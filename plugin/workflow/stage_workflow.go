@@ -0,0 +1,173 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+)
+
+// getStages resolves the workflow into the list of single stage workflows to
+// build and run, in dependency order. A sweep is resolved into one stage per
+// parameter combination, and a workflow without stages is returned as its
+// own single stage.
+func (cfg Workflow) getStages() ([]Workflow, error) {
+	if cfg.Sweep != nil {
+		return cfg.sweepRuns()
+	}
+
+	if len(cfg.Stages) == 0 {
+		return []Workflow{cfg}, nil
+	}
+
+	index := make(map[string]int, len(cfg.Stages))
+	for i, stage := range cfg.Stages {
+		if stage.Name == "" {
+			return nil, fmt.Errorf("stage %d has no name", i+1)
+		}
+		if _, ok := index[stage.Name]; ok {
+			return nil, fmt.Errorf("duplicate stage name: %s", stage.Name)
+		}
+		index[stage.Name] = i
+	}
+
+	dependents := make([][]int, len(cfg.Stages))
+	indegree := make([]int, len(cfg.Stages))
+	for i, stage := range cfg.Stages {
+		for _, inputContainer := range stage.InputContainer {
+			if inputContainer.Stage == "" {
+				continue
+			}
+			j, ok := index[inputContainer.Stage]
+			if !ok {
+				return nil, fmt.Errorf("stage %s: input refers to unknown stage %s", stage.Name, inputContainer.Stage)
+			}
+			if i == j {
+				return nil, fmt.Errorf("stage %s: input refers to its own output", stage.Name)
+			}
+			dependents[j] = append(dependents[j], i)
+			indegree[i]++
+		}
+	}
+
+	// topological order, ties broken by declaration order
+	order := make([]int, 0, len(cfg.Stages))
+	done := make([]bool, len(cfg.Stages))
+	for len(order) < len(cfg.Stages) {
+		next := -1
+		for i := range cfg.Stages {
+			if !done[i] && indegree[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			var remaining []string
+			for i, stage := range cfg.Stages {
+				if !done[i] {
+					remaining = append(remaining, stage.Name)
+				}
+			}
+			return nil, fmt.Errorf("stages form a dependency cycle: %s", strings.Join(remaining, ", "))
+		}
+		done[next] = true
+		order = append(order, next)
+		for _, dependent := range dependents[next] {
+			indegree[dependent]--
+		}
+	}
+
+	stages := make([]Workflow, 0, len(order))
+	for _, i := range order {
+		stage, err := cfg.stageWorkflow(cfg.Stages[i], index)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+
+	return stages, nil
+}
+
+// multiStage reports whether the workflow resolves into named stages.
+func (cfg Workflow) multiStage() bool {
+	return len(cfg.Stages) > 0 || cfg.Sweep != nil
+}
+
+// stageWorkflow converts a stage into a single stage workflow, naming every
+// input that refers to another stage after that stage's output container. An
+// input may pick one of several upstream output containers by Name. A stage
+// without Flags, Args or Resources takes those of the workflow, and its Env
+// extends the workflow's.
+func (cfg Workflow) stageWorkflow(stage Stage, index map[string]int) (Workflow, error) {
+	var dependsOn []string
+	inputContainers := make([]Container, 0, len(stage.InputContainer))
+	for _, inputContainer := range stage.InputContainer {
+		if inputContainer.Stage != "" {
+			dependsOn = append(dependsOn, inputContainer.Stage)
+			name, err := cfg.Stages[index[inputContainer.Stage]].upstreamOutput(inputContainer.Name)
+			if err != nil {
+				return Workflow{}, fmt.Errorf("stage %s: %v", stage.Name, err)
+			}
+			inputContainer.Name = name
+		}
+		inputContainers = append(inputContainers, inputContainer)
+	}
+
+	flags := stage.Flags
+	if flags == nil {
+		flags = cfg.Flags
+	}
+
+	args := stage.Args
+	if args == nil {
+		args = cfg.Args
+	}
+
+	resources := stage.Resources
+	if resources == nil {
+		resources = cfg.Resources
+	}
+
+	var env map[string]string
+	if len(cfg.Env)+len(stage.Env) > 0 {
+		env = make(map[string]string, len(cfg.Env)+len(stage.Env))
+		for key, value := range cfg.Env {
+			env[key] = value
+		}
+		for key, value := range stage.Env {
+			env[key] = value
+		}
+	}
+
+	return Workflow{
+		WorkflowName:         stage.Name,
+		ApplicationContainer: stage.ApplicationContainer,
+		InputContainer:       inputContainers,
+		OutputContainer:      stage.OutputContainer,
+		OutputContainers:     stage.OutputContainers,
+		Flags:                flags,
+		Args:                 args,
+		Env:                  env,
+		Resources:            resources,
+		dependsOn:            dependsOn,
+	}, nil
+}
+
+// upstreamOutput returns the name of the output container of the stage that
+// a downstream input binds. An empty name selects the only output container.
+func (stage Stage) upstreamOutput(name string) (string, error) {
+	outputContainers := stage.outputContainers()
+	if name == "" {
+		if len(outputContainers) != 1 {
+			return "", fmt.Errorf("stage %s has %d output containers, the input must name one", stage.Name, len(outputContainers))
+		}
+		return outputContainers[0].Name, nil
+	}
+
+	for _, outputContainer := range outputContainers {
+		if outputContainer.Name == name {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("stage %s has no output container %s", stage.Name, name)
+}
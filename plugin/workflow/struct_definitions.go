@@ -0,0 +1,95 @@
+package workflow
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Workflow is a workflow description: a single stage of one application,
+// input and output containers, a list of Stages, or a single stage Sweep.
+type Workflow struct {
+	Schema               string `json:"$schema,omitempty"`
+	Version              int    `json:",omitempty"`
+	WorkflowName         string
+	ApplicationContainer Container
+	InputContainer       []Container
+	OutputContainer      Container
+	OutputContainers     []Container       `json:",omitempty"`
+	Flags                []string          `json:",omitempty"`
+	Args                 []string          `json:",omitempty"`
+	Env                  map[string]string `json:",omitempty"`
+	Resources            *Resources        `json:",omitempty"`
+	Stages               []Stage           `json:",omitempty"`
+	Sweep                *Sweep            `json:",omitempty"`
+
+	// parameters is the sweep parameter combination of a sweep run.
+	parameters map[string]string
+	// dependsOn names the stages whose outputs this stage binds.
+	dependsOn []string
+}
+
+// Stage is one named stage of a multi-stage workflow.
+type Stage struct {
+	Name                 string
+	ApplicationContainer Container
+	InputContainer       []Container
+	OutputContainer      Container
+	OutputContainers     []Container       `json:",omitempty"`
+	Flags                []string          `json:",omitempty"`
+	Args                 []string          `json:",omitempty"`
+	Env                  map[string]string `json:",omitempty"`
+	Resources            *Resources        `json:",omitempty"`
+}
+
+// Container describes an application, input or output container. InPath
+// is the def file of an application container or the data directory of an
+// input container; an input container may instead name the Stage whose
+// output it binds.
+type Container struct {
+	Name     string
+	InPath   string `json:",omitempty"`
+	Size     Size   `json:",omitempty"`
+	Headroom string `json:",omitempty"`
+	Format   string `json:",omitempty"`
+	Stage    string `json:",omitempty"`
+
+	// mount is the name the container is bound at, when it differs from
+	// Name, as for the renamed output containers of a sweep run.
+	mount string
+}
+
+// Metadata is the metadata stored in a workflow container. That of an
+// output container records the run that produced it.
+type Metadata struct {
+	UUID             uuid.UUID
+	Name             string
+	CreationTime     time.Time
+	ExecutionCommand string
+	BuildKey         string            `json:",omitempty"`
+	Args             []string          `json:",omitempty"`
+	Env              map[string]string `json:",omitempty"`
+	Parameters       map[string]string `json:",omitempty"`
+	Invocation       *Invocation       `json:",omitempty"`
+	RecordTrail      *RecordTrail
+	ContentDigest    *ContentDigest `json:",omitempty"`
+	ImageDigest      *ContentDigest `json:",omitempty"`
+	RunRecord        *RunRecord     `json:",omitempty"`
+	Stdout           *OutputLog     `json:",omitempty"`
+	Stderr           *OutputLog     `json:",omitempty"`
+}
+
+// RecordTrail identifies, by UUID, the containers a run used and produced.
+type RecordTrail struct {
+	InputContainers      []ContainerRef
+	ApplicationContainer *ContainerRef
+	OutputContainer      *ContainerRef
+	SiblingOutputs       []ContainerRef `json:",omitempty"`
+}
+
+// ContainerRef identifies a container of a record trail.
+type ContainerRef struct {
+	Name  string
+	UUID  uuid.UUID
+	Stage string `json:",omitempty"`
+}
@@ -0,0 +1,226 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Sweep fans a single stage workflow out over parameter combinations,
+// given either as a Grid of values per parameter, of which every
+// combination is run, or as an explicit List of combinations. Each
+// parameter {name} in the Args and Env values of the workflow is replaced
+// by its value in the combination.
+type Sweep struct {
+	Grid map[string][]SweepValue `json:",omitempty"`
+	List []map[string]SweepValue `json:",omitempty"`
+}
+
+// SweepValue is a parameter value, given in a workflow description as a
+// string, a number or a boolean.
+type SweepValue string
+
+func (v *SweepValue) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*v = SweepValue(str)
+		return nil
+	}
+
+	var scalar interface{}
+	if err := json.Unmarshal(data, &scalar); err != nil {
+		return err
+	}
+	switch scalar.(type) {
+	case float64, bool:
+		*v = SweepValue(strings.TrimSpace(string(data)))
+		return nil
+	}
+
+	return fmt.Errorf("sweep value must be a string, number or boolean: %s", data)
+}
+
+// sweepIndex summarises the outputs of a sweep.
+type sweepIndex struct {
+	WorkflowName string
+	Runs         []sweepIndexEntry
+}
+
+type sweepIndexEntry struct {
+	Name             string
+	Parameters       map[string]string
+	OutputContainers []ContainerRef
+	Error            string `json:",omitempty"`
+}
+
+// combinations returns the parameter combinations of the sweep in a fixed
+// order: the List in order, or the Grid with the parameters sorted by name
+// and the last parameter varying fastest.
+func (sweep Sweep) combinations() ([]map[string]string, error) {
+	if len(sweep.Grid) > 0 && len(sweep.List) > 0 {
+		return nil, fmt.Errorf("sweep must have either a Grid or a List, not both")
+	}
+
+	var combinations []map[string]string
+	for _, entry := range sweep.List {
+		combination := make(map[string]string, len(entry))
+		for name, value := range entry {
+			combination[name] = string(value)
+		}
+		combinations = append(combinations, combination)
+	}
+
+	if len(sweep.Grid) > 0 {
+		names := make([]string, 0, len(sweep.Grid))
+		for name, values := range sweep.Grid {
+			if len(values) == 0 {
+				return nil, fmt.Errorf("sweep parameter %s has no values", name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		combinations = []map[string]string{{}}
+		for _, name := range names {
+			next := make([]map[string]string, 0, len(combinations)*len(sweep.Grid[name]))
+			for _, combination := range combinations {
+				for _, value := range sweep.Grid[name] {
+					extended := make(map[string]string, len(combination)+1)
+					for k, v := range combination {
+						extended[k] = v
+					}
+					extended[name] = string(value)
+					next = append(next, extended)
+				}
+			}
+			combinations = next
+		}
+	}
+
+	if len(combinations) == 0 {
+		return nil, fmt.Errorf("sweep has no parameter combinations")
+	}
+
+	return combinations, nil
+}
+
+// sweepRuns expands the workflow into one single stage workflow per
+// parameter combination. They share the application and input containers;
+// every output container is named after the combination, as in
+// predictions_k-8_model-knn.
+func (cfg Workflow) sweepRuns() ([]Workflow, error) {
+	if len(cfg.Stages) > 0 {
+		return nil, fmt.Errorf("a sweep cannot be combined with stages")
+	}
+
+	combinations, err := cfg.Sweep.combinations()
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]Workflow, 0, len(combinations))
+	names := make(map[string]bool)
+	for _, combination := range combinations {
+		suffix := sweepSuffix(combination)
+		if names[suffix] {
+			return nil, fmt.Errorf("sweep parameter combinations %s are not unique", suffix)
+		}
+		names[suffix] = true
+
+		run := cfg
+		run.Sweep = nil
+		run.WorkflowName = cfg.WorkflowName + "_" + suffix
+		run.parameters = combination
+
+		replacements := make([]string, 0, 2*len(combination))
+		for name, value := range combination {
+			replacements = append(replacements, "{"+name+"}", value)
+		}
+		replacer := strings.NewReplacer(replacements...)
+
+		run.Args = make([]string, 0, len(cfg.Args))
+		for _, arg := range cfg.Args {
+			run.Args = append(run.Args, replacer.Replace(arg))
+		}
+		if cfg.Env != nil {
+			run.Env = make(map[string]string, len(cfg.Env))
+			for key, value := range cfg.Env {
+				run.Env[key] = replacer.Replace(value)
+			}
+		}
+
+		run.OutputContainers = nil
+		for _, outputContainer := range cfg.outputContainers() {
+			outputContainer.mount = outputContainer.Name
+			outputContainer.Name += "_" + suffix
+			run.OutputContainers = append(run.OutputContainers, outputContainer)
+		}
+		run.OutputContainer = Container{}
+
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// sweepSuffix names a parameter combination, with the parameters sorted by
+// name and every character that is unsafe in a file name replaced.
+func sweepSuffix(combination map[string]string) string {
+	names := make([]string, 0, len(combination))
+	for name := range combination {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	safe := func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' {
+			return r
+		}
+		return '_'
+	}
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, strings.Map(safe, name)+"-"+strings.Map(safe, combination[name]))
+	}
+
+	return strings.Join(parts, "_")
+}
+
+// writeSweepIndex writes the summary of the sweep runs to
+// <WorkflowName>_sweep.json, recording the error of each failed run.
+func (cfg Workflow) writeSweepIndex(runs []Workflow, statuses []runStatus) (string, error) {
+	index := sweepIndex{WorkflowName: cfg.WorkflowName}
+
+	for i, run := range runs {
+		entry := sweepIndexEntry{
+			Name:       run.WorkflowName,
+			Parameters: run.parameters,
+		}
+		if statuses[i].err != nil {
+			entry.Error = statuses[i].err.Error()
+		}
+		for _, outputContainer := range run.outputContainers() {
+			ref, err := loadContainerRef(outputContainer.Name)
+			if err != nil {
+				ref = ContainerRef{Name: outputContainer.Name}
+			}
+			entry.OutputContainers = append(entry.OutputContainers, ref)
+		}
+		index.Runs = append(index.Runs, entry)
+	}
+
+	indexJSON, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := cfg.WorkflowName + "_sweep.json"
+	if err := os.WriteFile(path, indexJSON, 0644); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
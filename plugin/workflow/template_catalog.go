@@ -0,0 +1,280 @@
+package workflow
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// builtinTemplates are the workflow templates shipped with the package.
+//
+//go:embed templates
+var builtinTemplates embed.FS
+
+// templateDirsEnv lists further template directories, separated by colons.
+const templateDirsEnv = "APPTAINER_WORKFLOW_TEMPLATES"
+
+// workflowTemplate renders a workflow description and its companion files,
+// such as def files, from a set of parameters. A template is a directory
+// holding a template.json that declares it and the text/template sources of
+// its files.
+type workflowTemplate struct {
+	Description string
+	Parameters  []templateParameter
+	Files       []templateFile
+
+	name   string
+	source fs.FS
+}
+
+// templateParameter is a parameter of a template. Its Default may refer to
+// parameters declared before it.
+type templateParameter struct {
+	Name        string
+	Description string `json:",omitempty"`
+	Default     string `json:",omitempty"`
+	Required    bool   `json:",omitempty"`
+}
+
+// templateFile is a file rendered from the template Source to Path, which
+// may itself refer to parameters.
+type templateFile struct {
+	Path   string
+	Source string
+}
+
+// templateCatalog returns every template by name. Templates in dirs, then
+// in the directories listed in $APPTAINER_WORKFLOW_TEMPLATES, take
+// precedence over built-in templates of the same name.
+func templateCatalog(dirs []string) (map[string]*workflowTemplate, error) {
+	catalog := make(map[string]*workflowTemplate)
+
+	builtin, err := fs.Sub(builtinTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if err := addTemplates(catalog, builtin, "built-in templates"); err != nil {
+		return nil, err
+	}
+
+	if env := os.Getenv(templateDirsEnv); env != "" {
+		dirs = append(dirs, filepath.SplitList(env)...)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := addTemplates(catalog, os.DirFS(dirs[i]), dirs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return catalog, nil
+}
+
+func addTemplates(catalog map[string]*workflowTemplate, dir fs.FS, dirName string) error {
+	entries, err := fs.ReadDir(dir, ".")
+	if err != nil {
+		return fmt.Errorf("error reading template directory %s: %v", dirName, err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		source, err := fs.Sub(dir, entry.Name())
+		if err != nil {
+			return err
+		}
+
+		declaration, err := fs.ReadFile(source, "template.json")
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		tmpl := &workflowTemplate{name: entry.Name(), source: source}
+		decoder := json.NewDecoder(strings.NewReader(string(declaration)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(tmpl); err != nil {
+			return fmt.Errorf("error reading template %s in %s: %v", entry.Name(), dirName, err)
+		}
+		catalog[entry.Name()] = tmpl
+	}
+
+	return nil
+}
+
+// resolveParameters returns the value of every parameter of the template:
+// the one set in params or its default.
+func (tmpl *workflowTemplate) resolveParameters(params map[string]string) (map[string]string, error) {
+	values := make(map[string]string, len(tmpl.Parameters))
+	known := make(map[string]bool, len(tmpl.Parameters))
+
+	for _, param := range tmpl.Parameters {
+		known[param.Name] = true
+		if value, ok := params[param.Name]; ok {
+			values[param.Name] = value
+			continue
+		}
+		if param.Required {
+			return nil, fmt.Errorf("template %s needs parameter %s: %s", tmpl.name, param.Name, param.Description)
+		}
+		value, err := renderTemplate(param.Name, param.Default, values)
+		if err != nil {
+			return nil, err
+		}
+		values[param.Name] = value
+	}
+
+	var unknown []string
+	for name := range params {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("template %s has no parameter %s", tmpl.name, strings.Join(unknown, ", "))
+	}
+
+	return values, nil
+}
+
+// render renders the files of the template with params into dir and
+// returns their paths. Every rendered workflow description is validated
+// before anything is written, and existing files are only overwritten when
+// force is set.
+func (tmpl *workflowTemplate) render(params map[string]string, dir string, force bool) ([]string, error) {
+	values, err := tmpl.resolveParameters(params)
+	if err != nil {
+		return nil, err
+	}
+
+	type renderedFile struct {
+		path    string
+		content string
+	}
+
+	var files []renderedFile
+	for _, file := range tmpl.Files {
+		name, err := renderTemplate(file.Path, file.Path, values)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, filepath.Clean("/" + name)[1:])
+
+		source, err := fs.ReadFile(tmpl.source, file.Source)
+		if err != nil {
+			return nil, fmt.Errorf("error reading template %s: %v", tmpl.name, err)
+		}
+		content, err := renderTemplate(file.Source, string(source), values)
+		if err != nil {
+			return nil, err
+		}
+
+		if filepath.Ext(path) == ".json" {
+			if _, err := parseWorkflow([]byte(content), path, false); err != nil {
+				return nil, fmt.Errorf("template %s rendered an %v", tmpl.name, err)
+			}
+		}
+		if _, err := os.Stat(path); err == nil && !force {
+			return nil, fmt.Errorf("%s already exists", path)
+		}
+
+		files = append(files, renderedFile{path, content})
+	}
+
+	var paths []string
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+			return paths, err
+		}
+		if err := os.WriteFile(file.path, []byte(file.content), 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, file.path)
+	}
+
+	return paths, nil
+}
+
+func renderTemplate(name, text string, values map[string]string) (string, error) {
+	funcs := template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error parsing template %s: %v", name, err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, values); err != nil {
+		return "", fmt.Errorf("error rendering template %s: %v", name, err)
+	}
+
+	return b.String(), nil
+}
+
+// newWorkflow renders the template name into dir from params given as
+// name=value pairs, or lists the catalog when name is empty.
+func newWorkflow(name string, params []string, dir string, templateDirs []string, force bool) error {
+	catalog, err := templateCatalog(templateDirs)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		names := make([]string, 0, len(catalog))
+		for name := range catalog {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stdout, "%s: %s\n", name, catalog[name].Description)
+			for _, param := range catalog[name].Parameters {
+				line := fmt.Sprintf("    %s: %s", param.Name, param.Description)
+				if param.Required {
+					line += " (required)"
+				} else if param.Default != "" {
+					line += fmt.Sprintf(" (default %q)", param.Default)
+				}
+				fmt.Fprintln(os.Stdout, line)
+			}
+		}
+		return nil
+	}
+
+	tmpl, ok := catalog[name]
+	if !ok {
+		return fmt.Errorf("no workflow template named %s", name)
+	}
+
+	values := make(map[string]string, len(params))
+	for _, param := range params {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("parameter %q is not of the form name=value", param)
+		}
+		values[key] = value
+	}
+
+	paths, err := tmpl.render(values, dir, force)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fmt.Fprintf(os.Stdout, "Created: %s\n", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+#!/bin/bash
+#PBS -N single
+#PBS -j oe
+#PBS -l select=1:ncpus=4:mem=2048mb
+
+cd "${PBS_O_WORKDIR:-.}" || exit 1
+
+workflow=single.json
+status=0
+
+echo 'Running: stage single'
+started=$(date +%s)
+(
+	export APPTAINERENV_MODE=fast
+	export APPTAINERENV_THREADS=4
+	apptainer run -B data.sif:/data:image-src=/data,ro -B results.sif:/results:image-src=/results app.sif --input /data/data --label 'two words'
+) > single.stdout.log 2> single.stderr.log
+status=$?
+cat single.stdout.log
+cat single.stderr.log >&2
+apptainer workflow annotate "$workflow" --stage single --exit-status "$status" --started-at "$started" --stdout-file single.stdout.log --stderr-file single.stderr.log || status=1
+
+exit "$status"
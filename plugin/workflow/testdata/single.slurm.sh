@@ -0,0 +1,24 @@
+#!/bin/bash
+#SBATCH --job-name=single
+#SBATCH --output=single-%j.out
+#SBATCH --cpus-per-task=4
+#SBATCH --mem=2048M
+
+cd "${SLURM_SUBMIT_DIR:-.}" || exit 1
+
+workflow=single.json
+status=0
+
+echo 'Running: stage single'
+started=$(date +%s)
+(
+	export APPTAINERENV_MODE=fast
+	export APPTAINERENV_THREADS=4
+	apptainer run -B data.sif:/data:image-src=/data,ro -B results.sif:/results:image-src=/results app.sif --input /data/data --label 'two words'
+) > single.stdout.log 2> single.stderr.log
+status=$?
+cat single.stdout.log
+cat single.stderr.log >&2
+apptainer workflow annotate "$workflow" --stage single --exit-status "$status" --started-at "$started" --stdout-file single.stdout.log --stderr-file single.stderr.log || status=1
+
+exit "$status"
@@ -0,0 +1,33 @@
+#!/bin/bash
+#SBATCH --job-name=stages
+#SBATCH --output=stages-%j.out
+#SBATCH --cpus-per-task=8
+#SBATCH --mem=512M
+
+cd "${SLURM_SUBMIT_DIR:-.}" || exit 1
+
+workflow=stages.json
+status=0
+
+echo 'Running: stage prepare'
+started=$(date +%s)
+(
+	apptainer run -B data.sif:/data:image-src=/data,ro -B prepared.sif:/prepared:image-src=/prepared app.sif prepare
+) > prepare.stdout.log 2> prepare.stderr.log
+status=$?
+cat prepare.stdout.log
+cat prepare.stderr.log >&2
+apptainer workflow annotate "$workflow" --stage prepare --exit-status "$status" --started-at "$started" --stdout-file prepare.stdout.log --stderr-file prepare.stderr.log || status=1
+[ "$status" -eq 0 ] || exit "$status"
+
+echo 'Running: stage analyse'
+started=$(date +%s)
+(
+	apptainer run -B prepared.sif:/prepared:image-src=/prepared,ro -B results.sif:/results:image-src=/results app.sif analyse
+) > analyse.stdout.log 2> analyse.stderr.log
+status=$?
+cat analyse.stdout.log
+cat analyse.stderr.log >&2
+apptainer workflow annotate "$workflow" --stage analyse --exit-status "$status" --started-at "$started" --stdout-file analyse.stdout.log --stderr-file analyse.stderr.log || status=1
+
+exit "$status"
@@ -0,0 +1,40 @@
+#!/bin/bash
+#SBATCH --job-name=sweep
+#SBATCH --array=0-1
+#SBATCH --output=sweep-%A_%a.out
+
+cd "${SLURM_SUBMIT_DIR:-.}" || exit 1
+
+workflow=sweep.json
+status=0
+
+case "$SLURM_ARRAY_TASK_ID" in
+0)
+	echo 'Running: stage sweep_k-1_model-knn'
+	started=$(date +%s)
+	(
+		apptainer run -B data.sif:/data:image-src=/data,ro -B predictions_k-1_model-knn.sif:/predictions:image-src=/predictions_k-1_model-knn app.sif --k 1 --model knn
+	) > sweep_k-1_model-knn.stdout.log 2> sweep_k-1_model-knn.stderr.log
+	status=$?
+	cat sweep_k-1_model-knn.stdout.log
+	cat sweep_k-1_model-knn.stderr.log >&2
+	apptainer workflow annotate "$workflow" --stage sweep_k-1_model-knn --exit-status "$status" --started-at "$started" --stdout-file sweep_k-1_model-knn.stdout.log --stderr-file sweep_k-1_model-knn.stderr.log || status=1
+	;;
+1)
+	echo 'Running: stage sweep_k-8_model-knn'
+	started=$(date +%s)
+	(
+		apptainer run -B data.sif:/data:image-src=/data,ro -B predictions_k-8_model-knn.sif:/predictions:image-src=/predictions_k-8_model-knn app.sif --k 8 --model knn
+	) > sweep_k-8_model-knn.stdout.log 2> sweep_k-8_model-knn.stderr.log
+	status=$?
+	cat sweep_k-8_model-knn.stdout.log
+	cat sweep_k-8_model-knn.stderr.log >&2
+	apptainer workflow annotate "$workflow" --stage sweep_k-8_model-knn --exit-status "$status" --started-at "$started" --stdout-file sweep_k-8_model-knn.stdout.log --stderr-file sweep_k-8_model-knn.stderr.log || status=1
+	;;
+*)
+	echo "unknown array index $SLURM_ARRAY_TASK_ID" >&2
+	exit 1
+	;;
+esac
+
+exit "$status"
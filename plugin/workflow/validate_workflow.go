@@ -0,0 +1,442 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// workflowSchemaVersion is the version of the workflow description format,
+// described by schema/workflow-v1.schema.json.
+const workflowSchemaVersion = 1
+
+// reservedMounts are directories of the application container that an input
+// or output container must not be bound over.
+var reservedMounts = map[string]bool{
+	"bin": true, "boot": true, "dev": true, "etc": true, "home": true,
+	"lib": true, "lib64": true, "opt": true, "proc": true, "root": true,
+	"run": true, "sbin": true, "srv": true, "sys": true, "tmp": true,
+	"usr": true, "var": true,
+}
+
+// loadWorkflow reads and strictly validates the workflow description at
+// path. When forCreate is set, the InPath of every container to be built
+// must exist.
+func loadWorkflow(path string, forCreate bool) (Workflow, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return Workflow{}, err
+	}
+
+	return parseWorkflow(file, path, forCreate)
+}
+
+// parseWorkflow decodes and strictly validates the workflow description
+// read from path.
+func parseWorkflow(file []byte, path string, forCreate bool) (Workflow, error) {
+	var cfg Workflow
+
+	var problems []string
+	checkJSONFields("", json.RawMessage(file), reflect.TypeOf(cfg), &problems)
+	if len(problems) == 0 {
+		if err := json.Unmarshal(file, &cfg); err != nil {
+			return cfg, err
+		}
+		problems = cfg.validate(forCreate)
+	}
+
+	if len(problems) > 0 {
+		return cfg, invalidWorkflow(path, problems)
+	}
+
+	return cfg, nil
+}
+
+func invalidWorkflow(name string, problems []string) error {
+	if name == "" {
+		return fmt.Errorf("invalid workflow description:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return fmt.Errorf("invalid workflow description %s:\n\t%s", name, strings.Join(problems, "\n\t"))
+}
+
+// checkJSONFields checks that raw decodes into a value of type t, reporting
+// every unknown or mistyped field with its path.
+func checkJSONFields(path string, raw json.RawMessage, t reflect.Type, problems *[]string) {
+	report := func(format string, a ...interface{}) {
+		where := path
+		if where == "" {
+			where = "workflow"
+		}
+		*problems = append(*problems, where+": "+fmt.Sprintf(format, a...))
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return
+	}
+
+	if reflect.PtrTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+		if err := json.Unmarshal(raw, reflect.New(t).Interface()); err != nil {
+			report("%v", err)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err != nil {
+			report("expected an object")
+			return
+		}
+
+		fields := make(map[string]reflect.StructField)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" {
+				name = field.Name
+			}
+			fields[name] = field
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			field, ok := fields[key]
+			if !ok {
+				known := make([]string, 0, len(fields))
+				for name := range fields {
+					known = append(known, name)
+				}
+				if suggestion := closestName(key, known); suggestion != "" {
+					report("unknown field %q, did you mean %q?", key, suggestion)
+				} else {
+					report("unknown field %q", key)
+				}
+				continue
+			}
+			checkJSONFields(joinJSONPath(path, key), object[key], field.Type, problems)
+		}
+	case reflect.Slice:
+		var array []json.RawMessage
+		if err := json.Unmarshal(raw, &array); err != nil {
+			report("expected a list")
+			return
+		}
+		for i, element := range array {
+			checkJSONFields(fmt.Sprintf("%s[%d]", path, i), element, t.Elem(), problems)
+		}
+	case reflect.Map:
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err != nil {
+			report("expected an object")
+			return
+		}
+		for key, value := range object {
+			checkJSONFields(joinJSONPath(path, key), value, t.Elem(), problems)
+		}
+	default:
+		if err := json.Unmarshal(raw, reflect.New(t).Interface()); err != nil {
+			report("expected a %s", jsonKind(t))
+		}
+	}
+}
+
+func joinJSONPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "whole number"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return t.String()
+}
+
+// closestName returns the known name that key most likely misspells, if
+// any: one equal but for case, or within two edits.
+func closestName(key string, known []string) string {
+	sort.Strings(known)
+
+	best, bestDistance := "", 3
+	for _, name := range known {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// validate checks the decoded workflow description for mistakes the JSON
+// structure cannot express, returning each with the path of its field.
+func (cfg Workflow) validate(forCreate bool) []string {
+	var problems []string
+	report := func(path, format string, a ...interface{}) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, a...))
+	}
+
+	if cfg.Version != 0 && cfg.Version != workflowSchemaVersion {
+		report("Version", "unsupported workflow format version %d, expected %d", cfg.Version, workflowSchemaVersion)
+	}
+	if cfg.WorkflowName == "" {
+		report("WorkflowName", "missing workflow name")
+	}
+
+	type stageFields struct {
+		path                 string
+		applicationContainer Container
+		inputContainers      []Container
+		outputContainer      Container
+		outputContainers     []Container
+		flags                []string
+		args                 []string
+		env                  map[string]string
+		resources            *Resources
+	}
+
+	var stages []stageFields
+	if len(cfg.Stages) == 0 {
+		stages = append(stages, stageFields{"", cfg.ApplicationContainer, cfg.InputContainer, cfg.OutputContainer, cfg.OutputContainers, cfg.Flags, cfg.Args, cfg.Env, cfg.Resources})
+	} else {
+		if cfg.ApplicationContainer.Name != "" || len(cfg.InputContainer) > 0 || cfg.OutputContainer.Name != "" || len(cfg.OutputContainers) > 0 {
+			report("Stages", "a workflow with stages declares its containers in the stages")
+		}
+		if cfg.Sweep != nil {
+			report("Sweep", "a sweep cannot be combined with stages")
+		}
+		for i, stage := range cfg.Stages {
+			stages = append(stages, stageFields{fmt.Sprintf("Stages[%d].", i), stage.ApplicationContainer, stage.InputContainer, stage.OutputContainer, stage.OutputContainers, stage.Flags, stage.Args, stage.Env, stage.Resources})
+		}
+		problems = append(problems, runFlags(cfg.Flags).validate("Flags")...)
+		problems = append(problems, envVars(cfg.Env).validate("Env")...)
+	}
+
+	// containers shared between stages must be declared identically, and
+	// every output container belongs to a single stage
+	declared := make(map[string]Container)
+	declaredAt := make(map[string]string)
+	outputs := make(map[string]string)
+
+	declare := func(path, role string, container Container) {
+		if container.Name == "" {
+			report(path+"Name", "missing %s container name", role)
+			return
+		}
+		if strings.ContainsAny(container.Name, "/:,") || strings.HasPrefix(container.Name, ".") {
+			report(path+"Name", "container name %q must not contain '/', ':' or ',', or start with '.'", container.Name)
+		}
+		if previous, ok := declared[container.Name]; ok {
+			if previous != container || role == "output" || outputs[container.Name] != "" {
+				report(path+"Name", "duplicate container name %q, also declared at %s", container.Name, declaredAt[container.Name])
+			}
+			return
+		}
+		declared[container.Name] = container
+		declaredAt[container.Name] = strings.TrimSuffix(path, ".")
+		if role == "output" {
+			outputs[container.Name] = path
+		}
+	}
+
+	for _, stage := range stages {
+		appPath := stage.path + "ApplicationContainer."
+		declare(appPath, "application", stage.applicationContainer)
+		if forCreate && stage.applicationContainer.InPath == "" {
+			report(appPath+"InPath", "missing def file of application container")
+		} else if forCreate {
+			problems = append(problems, checkInPath(appPath+"InPath", stage.applicationContainer.InPath)...)
+		}
+
+		mounts := make(map[string]string)
+		mount := func(path string, container Container) {
+			point := strings.TrimPrefix(container.mountPoint(), "/")
+			if reservedMounts[point] {
+				report(path+"Name", "container %q would be bound over the system directory /%s", container.Name, point)
+			}
+			if other, ok := mounts[point]; ok {
+				report(path+"Name", "container %q would be bound at /%s, as is %s", container.Name, point, other)
+			}
+			mounts[point] = strings.TrimSuffix(path, ".")
+		}
+
+		for i, inputContainer := range stage.inputContainers {
+			path := fmt.Sprintf("%sInputContainer[%d].", stage.path, i)
+			if inputContainer.Stage != "" {
+				if len(cfg.Stages) == 0 {
+					report(path+"Stage", "only inputs of a stage can refer to another stage")
+				}
+				if inputContainer.InPath != "" {
+					report(path+"InPath", "an input bound from stage %s has no InPath", inputContainer.Stage)
+				}
+				continue
+			}
+			declare(path, "input", inputContainer)
+			mount(path, inputContainer)
+			if inputContainer.Size < 0 && inputContainer.Size != autoPartitionSize {
+				report(path+"Size", "size must not be negative")
+			}
+			if inputContainer.Format != "" && inputContainer.Format != formatExt3 && inputContainer.Format != formatSquashfs {
+				report(path+"Format", "unknown format %q, expected %q or %q", inputContainer.Format, formatExt3, formatSquashfs)
+			}
+			if inputContainer.Headroom != "" {
+				if _, err := parseHeadroom(inputContainer.Headroom); err != nil {
+					report(path+"Headroom", "%v", err)
+				}
+			}
+			if forCreate {
+				if inputContainer.InPath == "" {
+					report(path+"InPath", "missing input data path")
+				} else {
+					problems = append(problems, checkInPath(path+"InPath", inputContainer.InPath)...)
+				}
+			}
+		}
+
+		var outputContainers []Container
+		var outputPaths []string
+		if stage.outputContainer.Name != "" || stage.outputContainer.Size != 0 {
+			outputContainers = append(outputContainers, stage.outputContainer)
+			outputPaths = append(outputPaths, stage.path+"OutputContainer.")
+		}
+		for i, outputContainer := range stage.outputContainers {
+			outputContainers = append(outputContainers, outputContainer)
+			outputPaths = append(outputPaths, fmt.Sprintf("%sOutputContainers[%d].", stage.path, i))
+		}
+		if len(outputContainers) == 0 {
+			report(strings.TrimSuffix(stage.path, ".")+"OutputContainer", "missing output container")
+		}
+		for i, outputContainer := range outputContainers {
+			path := outputPaths[i]
+			declare(path, "output", outputContainer)
+			mount(path, outputContainer)
+			if outputContainer.Size == 0 || outputContainer.Size == autoPartitionSize {
+				report(path+"Size", "output container needs a size greater than zero")
+			}
+			if outputContainer.InPath != "" || outputContainer.Format != "" || outputContainer.Stage != "" {
+				report(strings.TrimSuffix(path, "."), "an output container has only a Name and a Size")
+			}
+		}
+
+		problems = append(problems, runFlags(stage.flags).validate(stage.path+"Flags")...)
+		problems = append(problems, envVars(stage.env).validate(stage.path+"Env")...)
+		if stage.resources != nil {
+			if stage.resources.CPUs < 0 {
+				report(stage.path+"Resources.CPUs", "must not be negative")
+			}
+			if stage.resources.Memory < 0 {
+				report(stage.path+"Resources.Memory", "must be a size")
+			}
+		}
+	}
+
+	if cfg.Sweep != nil {
+		if _, err := cfg.Sweep.combinations(); err != nil {
+			report("Sweep", "%v", err)
+		}
+	}
+
+	if len(problems) == 0 {
+		if _, err := cfg.getStages(); err != nil {
+			report("Stages", "%v", err)
+		}
+	}
+
+	return problems
+}
+
+// runFlags are the extra flags passed to apptainer run. Each is an option,
+// with any value joined to it as in --env-file=vars, so that none can be
+// taken for the image.
+type runFlags []string
+
+func (flags runFlags) validate(path string) []string {
+	var problems []string
+	for i, flag := range flags {
+		if !strings.HasPrefix(flag, "-") {
+			problems = append(problems, fmt.Sprintf("%s[%d]: flag %q must start with '-', give its value as --flag=value", path, i, flag))
+		}
+	}
+	return problems
+}
+
+// envVars are the environment variables of an application.
+type envVars map[string]string
+
+func (env envVars) validate(path string) []string {
+	var problems []string
+	for key := range env {
+		if key == "" || strings.ContainsAny(key, "= ") {
+			problems = append(problems, fmt.Sprintf("%s: invalid environment variable name %q", path, key))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func checkInPath(path, inPath string) []string {
+	if _, err := os.Stat(inPath); err != nil {
+		return []string{fmt.Sprintf("%s: %q does not exist", path, inPath)}
+	}
+	return nil
+}
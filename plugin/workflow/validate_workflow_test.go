@@ -0,0 +1,151 @@
+package workflow
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateWorkflow(t *testing.T) {
+	const (
+		app     = `"ApplicationContainer": {"Name": "app", "InPath": "app.def"}`
+		data    = `{"Name": "data", "InPath": "data"}`
+		results = `"OutputContainer": {"Name": "results", "Size": "4MiB"}`
+	)
+
+	tests := []struct {
+		name      string
+		json      string
+		forCreate bool
+		want      []string
+	}{
+		{
+			name: "valid",
+			json: `{"WorkflowName": "w", ` + app + `, "InputContainer": [` + data + `], ` + results + `}`,
+		},
+		{
+			name: "unknown fields",
+			json: `{"WorkflowName": "w", "Bogus": 1, "ApplicationContainer": {"Name": "app", "inpath": "app.def"},
+				"InputContainer": [{"Name": "data", "Sise": 1}], "OutputContainer": {"Name": "results", "Size": "4MiB", "Formt": "ext3"}}`,
+			want: []string{
+				`ApplicationContainer: unknown field "inpath", did you mean "InPath"?`,
+				`workflow: unknown field "Bogus"`,
+				`InputContainer[0]: unknown field "Sise", did you mean "Size"?`,
+				`OutputContainer: unknown field "Formt", did you mean "Format"?`,
+			},
+		},
+		{
+			name: "unknown stage field",
+			json: `{"WorkflowName": "w", "Stages": [{"Name": "only", ` + app + `, "Arg": ["x"], ` + results + `}]}`,
+			want: []string{`Stages[0]: unknown field "Arg", did you mean "Args"?`},
+		},
+		{
+			name: "mistyped fields",
+			json: `{"WorkflowName": "w", ` + app + `, ` + results + `, "Args": "--verbose", "Resources": {"CPUs": "4"}}`,
+			want: []string{
+				`Args: expected a list`,
+				`Resources.CPUs: expected a whole number`,
+			},
+		},
+		{
+			name: "duplicate names",
+			json: `{"WorkflowName": "w", ` + app + `, "InputContainer": [{"Name": "app", "InPath": "data"}],
+				"OutputContainer": {"Name": "results", "Size": 1}, "OutputContainers": [{"Name": "results", "Size": 2}]}`,
+			want: []string{
+				`InputContainer[0].Name: duplicate container name "app", also declared at ApplicationContainer`,
+				`OutputContainers[0].Name: duplicate container name "results", also declared at OutputContainer`,
+				`OutputContainers[0].Name: container "results" would be bound at /results, as is OutputContainer`,
+			},
+		},
+		{
+			name: "output of two stages",
+			json: `{"WorkflowName": "w", "Stages": [
+				{"Name": "one", ` + app + `, ` + results + `},
+				{"Name": "two", ` + app + `, ` + results + `}]}`,
+			want: []string{`Stages[1].OutputContainer.Name: duplicate container name "results", also declared at Stages[0].OutputContainer`},
+		},
+		{
+			name: "mount collisions",
+			json: `{"WorkflowName": "w", ` + app + `, "InputContainer": [` + data + `, ` + data + `, {"Name": "etc", "InPath": "etc"}], ` + results + `}`,
+			want: []string{
+				`InputContainer[1].Name: container "data" would be bound at /data, as is InputContainer[0]`,
+				`InputContainer[2].Name: container "etc" would be bound over the system directory /etc`,
+			},
+		},
+		{
+			name: "container names",
+			json: `{"WorkflowName": "w", "ApplicationContainer": {"Name": "my app", "InPath": "app.def"},
+				"InputContainer": [{"Name": "a:b", "InPath": "data"}, {"Name": ".hidden", "InPath": "data"}], ` + results + `}`,
+			want: []string{
+				`InputContainer[0].Name: container name "a:b" must not contain '/', ':' or ',', or start with '.'`,
+				`InputContainer[1].Name: container name ".hidden" must not contain '/', ':' or ',', or start with '.'`,
+			},
+		},
+		{
+			name:      "missing InPath",
+			json:      `{"WorkflowName": "w", "ApplicationContainer": {"Name": "app"}, "InputContainer": [{"Name": "data"}, {"Name": "more", "InPath": "missing"}], ` + results + `}`,
+			forCreate: true,
+			want: []string{
+				`ApplicationContainer.InPath: missing def file of application container`,
+				`InputContainer[0].InPath: missing input data path`,
+				`InputContainer[1].InPath: "missing" does not exist`,
+			},
+		},
+		{
+			name: "InPath only checked for create",
+			json: `{"WorkflowName": "w", "ApplicationContainer": {"Name": "app"}, "InputContainer": [{"Name": "data"}], ` + results + `}`,
+		},
+		{
+			name: "zero sizes",
+			json: `{"WorkflowName": "w", ` + app + `, "OutputContainer": {"Name": "results"},
+				"OutputContainers": [{"Name": "zero", "Size": 0}, {"Name": "auto", "Size": "auto"}, {"Name": "none", "Size": "0GiB"}]}`,
+			want: []string{
+				`OutputContainer.Size: output container needs a size greater than zero`,
+				`OutputContainers[0].Size: output container needs a size greater than zero`,
+				`OutputContainers[1].Size: output container needs a size greater than zero`,
+				`OutputContainers[2].Size: output container needs a size greater than zero`,
+			},
+		},
+		{
+			name: "negative size",
+			json: `{"WorkflowName": "w", ` + app + `, "InputContainer": [{"Name": "data", "InPath": "data", "Size": -1}], ` + results + `}`,
+			want: []string{`InputContainer[0].Size: size must not be negative: -1`},
+		},
+		{
+			name: "missing names",
+			json: `{"ApplicationContainer": {"InPath": "app.def"}, "OutputContainer": {"Size": 1}}`,
+			want: []string{
+				`WorkflowName: missing workflow name`,
+				`ApplicationContainer.Name: missing application container name`,
+				`OutputContainer.Name: missing output container name`,
+			},
+		},
+		{
+			name: "flags",
+			json: `{"WorkflowName": "w", ` + app + `, ` + results + `, "Flags": ["--cleanenv", "vars"]}`,
+			want: []string{`Flags[1]: flag "vars" must start with '-', give its value as --flag=value`},
+		},
+	}
+
+	chdirTemp(t)
+	writeTestFile(t, "app.def", testDefFile)
+	writeTestFile(t, "data/input.txt", "1,2,3\n")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseWorkflow([]byte(tt.json), "", tt.forCreate)
+
+			var got []string
+			if err != nil {
+				lines := strings.Split(err.Error(), "\n\t")
+				if lines[0] != "invalid workflow description:" {
+					t.Fatalf("error = %v, want an invalid workflow description", err)
+				}
+				got = lines[1:]
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems:\n\t%s\nwant:\n\t%s", strings.Join(got, "\n\t"), strings.Join(tt.want, "\n\t"))
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apptainer/sif/v2/pkg/sif"
+	uuid "github.com/satori/go.uuid"
+)
+
+// verifyWorkflow checks the containers of the workflow description at path,
+// or the output container image at path and every container in its record
+// trail, for drift from their metadata and, given a verification key, for
+// valid signatures.
+func verifyWorkflow(ctx context.Context, path string, opts workflowOptions) error {
+	dir := "."
+	var names []string
+
+	if filepath.Ext(path) == ".sif" {
+		dir = filepath.Dir(path)
+		names = append(names, strings.TrimSuffix(filepath.Base(path), ".sif"))
+
+		metadata, err := readContainerMetadata(path)
+		if err != nil {
+			return err
+		}
+		if metadata.RecordTrail != nil {
+			for _, ref := range metadata.RecordTrail.refs() {
+				names = append(names, ref.Name)
+			}
+		}
+	} else {
+		cfg, err := loadWorkflow(path, false)
+		if err != nil {
+			return err
+		}
+
+		stages, err := cfg.getStages()
+		if err != nil {
+			return err
+		}
+		for _, stage := range stages {
+			names = append(names, stage.ApplicationContainer.Name)
+			for _, inputContainer := range stage.InputContainer {
+				names = append(names, inputContainer.Name)
+			}
+			for _, outputContainer := range stage.outputContainers() {
+				names = append(names, outputContainer.Name)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	failed, total := 0, 0
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		total++
+
+		problems := verifyContainer(ctx, dir, name, opts)
+		if len(problems) == 0 {
+			fmt.Fprintf(os.Stdout, "Verified: %s\n", name)
+			continue
+		}
+		failed++
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stdout, "Drift: %s: %s\n", name, problem)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d containers failed verification", failed, total)
+	}
+
+	fmt.Fprintln(os.Stdout, "workflow has been verified")
+
+	return nil
+}
+
+// verifyContainer returns every way in which the container image name.sif
+// in dir no longer matches its metadata or signature.
+func verifyContainer(ctx context.Context, dir, name string, opts workflowOptions) []string {
+	path := filepath.Join(dir, name+".sif")
+
+	id, err := readContainerID(path)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot load container: %v", err)}
+	}
+
+	metadata, err := readContainerMetadata(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	if opts.verifier != nil {
+		if err := opts.verifyContainerSignature(path); err != nil {
+			problems = append(problems, fmt.Sprintf("signature verification failed: %v", err))
+		}
+	}
+
+	if metadata.UUID != id {
+		problems = append(problems, fmt.Sprintf("metadata UUID %s does not match container UUID %s", metadata.UUID, id))
+	}
+
+	if metadata.RecordTrail != nil {
+		for _, ref := range metadata.RecordTrail.refs() {
+			refID := id
+			if ref.Name != name {
+				if refID, err = readContainerID(filepath.Join(dir, ref.Name+".sif")); err != nil {
+					problems = append(problems, fmt.Sprintf("record trail refers to %s, which cannot be loaded: %v", ref.Name, err))
+					continue
+				}
+			}
+			if ref.UUID != refID {
+				problems = append(problems, fmt.Sprintf("record trail refers to %s %s but the container UUID is %s", ref.Name, ref.UUID, refID))
+			}
+		}
+	}
+
+	if metadata.ContentDigest != nil {
+		digest, err := getContentDigest(ctx, path, opts.tmpDir)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cannot compute content digest: %v", err))
+		} else if digest == nil {
+			problems = append(problems, "data partition is missing")
+		} else if digest.MerkleRoot != metadata.ContentDigest.MerkleRoot {
+			problems = append(problems, fmt.Sprintf("content Merkle root %s does not match recorded %s", digest.MerkleRoot, metadata.ContentDigest.MerkleRoot))
+			problems = append(problems, diffContentDigests("file", metadata.ContentDigest, digest)...)
+		}
+	}
+
+	if metadata.ImageDigest != nil {
+		digest, err := getImageDigest(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cannot compute image digest: %v", err))
+		} else if digest.MerkleRoot != metadata.ImageDigest.MerkleRoot {
+			problems = append(problems, fmt.Sprintf("image Merkle root %s does not match recorded %s", digest.MerkleRoot, metadata.ImageDigest.MerkleRoot))
+			problems = append(problems, diffContentDigests("object", metadata.ImageDigest, digest)...)
+		}
+	}
+
+	return problems
+}
+
+// diffContentDigests lists the files, or objects, that were modified,
+// removed or added between the recorded and the current digest.
+func diffContentDigests(kind string, recorded, current *ContentDigest) []string {
+	currentFiles := make(map[string]FileDigest, len(current.Files))
+	for _, file := range current.Files {
+		currentFiles[file.Path] = file
+	}
+
+	var diffs []string
+	for _, file := range recorded.Files {
+		now, ok := currentFiles[file.Path]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s removed: %s", kind, file.Path))
+		} else if now.SHA256 != file.SHA256 {
+			diffs = append(diffs, fmt.Sprintf("%s modified: %s", kind, file.Path))
+		}
+		delete(currentFiles, file.Path)
+	}
+	for _, file := range current.Files {
+		if _, ok := currentFiles[file.Path]; ok {
+			diffs = append(diffs, fmt.Sprintf("%s added: %s", kind, file.Path))
+		}
+	}
+
+	return diffs
+}
+
+// refs returns every container referenced by the record trail.
+func (rt RecordTrail) refs() []ContainerRef {
+	refs := append([]ContainerRef{}, rt.InputContainers...)
+	if rt.ApplicationContainer != nil {
+		refs = append(refs, *rt.ApplicationContainer)
+	}
+	if rt.OutputContainer != nil {
+		refs = append(refs, *rt.OutputContainer)
+	}
+	return append(refs, rt.SiblingOutputs...)
+}
+
+func readContainerID(path string) (uuid.UUID, error) {
+	img, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	id, err := uuid.FromString(img.ID())
+	if err != nil {
+		img.UnloadContainer()
+		return uuid.Nil, err
+	}
+
+	if err := img.UnloadContainer(); err != nil {
+		return uuid.Nil, err
+	}
+
+	return id, nil
+}
+
+// readContainerMetadata returns the most recently added metadata object of
+// the container image at path.
+func readContainerMetadata(path string) (Metadata, error) {
+	var metadata Metadata
+
+	img, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return metadata, err
+	}
+	defer img.UnloadContainer()
+
+	descriptors, err := img.GetDescriptors(sif.WithDataType(sif.DataGenericJSON))
+	if err != nil {
+		return metadata, fmt.Errorf("could not retrieve container descriptions: %v", err)
+	}
+
+	var data []byte
+	for _, descriptor := range descriptors {
+		if descriptor.Name() != "metadata" && descriptor.Name() != "metadata.json" {
+			continue
+		}
+		if data, err = descriptor.GetData(); err != nil {
+			return metadata, err
+		}
+	}
+	if data == nil {
+		return metadata, fmt.Errorf("%s has no metadata", path)
+	}
+
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return metadata, fmt.Errorf("error reading metadata of %s: %v", path, err)
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,78 @@
+package workflow
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/apptainer/sif/v2/pkg/sif"
+)
+
+// tamperObject overwrites the first byte of the first object of the given
+// data type in the container image at path, bypassing the SIF library.
+func tamperObject(t *testing.T, path string, dataType sif.DataType) {
+	t.Helper()
+
+	img, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor, err := img.GetDescriptor(sif.WithDataType(dataType))
+	if err != nil {
+		img.UnloadContainer()
+		t.Fatal(err)
+	}
+	offset := descriptor.Offset()
+	if err := img.UnloadContainer(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt([]byte{'#'}, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyDetectsModifiedApplication(t *testing.T) {
+	wf := testWorkflow(t)
+	if err := Create(context.Background(), wf, WithRuntime(&FakeRuntime{})); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if digest := readTestMetadata(t, "app").ImageDigest; digest == nil || len(digest.Files) != 1 {
+		t.Fatalf("application image digest = %+v, want one leaf for its def file", digest)
+	}
+	if problems := verifyContainer(context.Background(), ".", "app", workflowOptions{}); len(problems) != 0 {
+		t.Fatalf("unmodified application container: %q", problems)
+	}
+
+	tamperObject(t, "app.sif", sif.DataDeffile)
+
+	problems := verifyContainer(context.Background(), ".", "app", workflowOptions{})
+	if len(problems) != 2 {
+		t.Fatalf("problems = %q, want a Merkle root mismatch and the modified object", problems)
+	}
+	if want := "object modified: 1/Def.FILE"; problems[1] != want {
+		t.Errorf("problem = %q, want %q", problems[1], want)
+	}
+}
+
+func TestImageDigestIgnoresMetadata(t *testing.T) {
+	wf := testWorkflow(t)
+	if err := Create(context.Background(), wf, WithRuntime(&FakeRuntime{})); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	digest, err := getImageDigest("app.sif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded := readTestMetadata(t, "app").ImageDigest; !reflect.DeepEqual(digest, recorded) {
+		t.Errorf("image digest = %+v, want the one recorded before the metadata was added, %+v", digest, recorded)
+	}
+}
@@ -0,0 +1,61 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDefFile = `Bootstrap: docker
+From: alpine
+
+%runscript
+    cat /data/data/input.txt > /results/output.txt
+`
+
+// chdirTemp makes a new temporary directory the working directory for the
+// rest of the test, as the workflow operations work in the current
+// directory.
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return dir
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testWorkflow writes the def file and input data of a single stage
+// workflow into a new working directory and returns its description.
+func testWorkflow(t *testing.T) Workflow {
+	t.Helper()
+
+	chdirTemp(t)
+	writeTestFile(t, "app.def", testDefFile)
+	writeTestFile(t, "data/input.txt", "1,2,3\n")
+
+	return Workflow{
+		WorkflowName:         "test_workflow",
+		ApplicationContainer: Container{Name: "app", InPath: "app.def"},
+		InputContainer:       []Container{{Name: "data", InPath: "data"}},
+		OutputContainer:      Container{Name: "results", Size: 4 << 20},
+	}
+}